@@ -3,6 +3,8 @@ package acdb
 
 import (
 	"encoding/json"
+	"errors"
+	"io/fs"
 	"log"
 	"os"
 	"path"
@@ -11,6 +13,79 @@ import (
 	"github.com/mohanson/libraries/go/lru"
 )
 
+// ErrNotExist is returned by drivers when the key does not exist. Errors returned by Client wrap it, so callers should
+// test for it with errors.Is. It also matches fs.ErrNotExist, hence errors.Is(err, os.ErrNotExist) keeps working, but
+// os.IsNotExist does not look through the wrapping.
+var ErrNotExist error = notExistError{}
+
+type notExistError struct{}
+
+// Error implements the error interface.
+func (notExistError) Error() string {
+	return "acdb: key does not exist"
+}
+
+// Is reports whether target is fs.ErrNotExist.
+func (notExistError) Is(target error) bool {
+	return target == fs.ErrNotExist
+}
+
+// OpError is the error type returned by Client. It describes the operation and the key that caused the error.
+type OpError struct {
+	// Op is the operation which caused the error, such as "get", "set" or "del".
+	Op string
+	// Key is the key the operation was performed on.
+	Key string
+	// Err is the error that occurred during the operation.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *OpError) Error() string {
+	return "acdb: " + e.Op + " " + e.Key + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeError is returned when the value of a key exists but cannot be decoded into the requested type.
+type DecodeError struct {
+	// Key is the key whose value failed to decode.
+	Key string
+	// Err is the error returned by the decoder.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *DecodeError) Error() string {
+	return "acdb: decode " + e.Key + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// EncodeError is returned when a value cannot be encoded before it is set.
+type EncodeError struct {
+	// Key is the key whose value failed to encode.
+	Key string
+	// Err is the error returned by the encoder.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *EncodeError) Error() string {
+	return "acdb: encode " + e.Key + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
 // Driver is the interface that wraps the Set/Get and Del method.
 //
 // Get gets and returns the bytes or any error encountered. If the key does not exist, ErrNotExist will be returned.
@@ -37,6 +112,9 @@ func NewMemDriver() *MemDriver {
 
 // Del the value of a key.
 func (d *MemDriver) Del(k string) error {
+	if _, b := d.data[k]; !b {
+		return ErrNotExist
+	}
 	delete(d.data, k)
 	return nil
 }
@@ -47,7 +125,7 @@ func (d *MemDriver) Get(k string) ([]byte, error) {
 	if b {
 		return v, nil
 	}
-	return nil, os.ErrNotExist
+	return nil, ErrNotExist
 }
 
 // Set the value of a key.
@@ -72,12 +150,20 @@ func NewDocDriver(root string) *DocDriver {
 
 // Del the value of a key.
 func (d *DocDriver) Del(k string) error {
-	return os.Remove(path.Join(d.root, k))
+	err := os.Remove(path.Join(d.root, k))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotExist
+	}
+	return err
 }
 
 // Get the value of a key.
 func (d *DocDriver) Get(k string) ([]byte, error) {
-	return os.ReadFile(path.Join(d.root, k))
+	v, err := os.ReadFile(path.Join(d.root, k))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+	return v, err
 }
 
 // Set the value of a key.
@@ -107,6 +193,9 @@ func NewLruDriver(size int) *LruDriver {
 
 // Del the value of a key.
 func (d *LruDriver) Del(k string) error {
+	if !d.data.Has(k) {
+		return ErrNotExist
+	}
 	d.data.Del(k)
 	return nil
 }
@@ -117,7 +206,7 @@ func (d *LruDriver) Get(k string) ([]byte, error) {
 	if b {
 		return v, nil
 	}
-	return nil, os.ErrNotExist
+	return nil, ErrNotExist
 }
 
 // Set the value of a key.
@@ -141,15 +230,13 @@ func NewMapDriver(root string) *MapDriver {
 	}
 }
 
-// Del the value of a key.
+// Del the value of a key. The file system is the source of truth, so ErrNotExist is reported only if the key is missing
+// there.
 func (d *MapDriver) Del(k string) error {
-	if err := d.lru.Del(k); err != nil {
+	if err := d.lru.Del(k); err != nil && !errors.Is(err, ErrNotExist) {
 		return err
 	}
-	if err := d.doc.Del(k); err != nil {
-		return err
-	}
-	return nil
+	return d.doc.Del(k)
 }
 
 // Get the value of a key.
@@ -181,7 +268,8 @@ func (d *MapDriver) Set(k string, v []byte) error {
 	return nil
 }
 
-// Client is a actuator of the given drive. Do not worry, Is's concurrency-safety.
+// Client is a actuator of the given drive. Do not worry, Is's concurrency-safety. All errors returned by the driver are
+// wrapped in an *OpError.
 type Client struct {
 	driver Driver
 	log    int
@@ -193,20 +281,26 @@ func NewClient(driver Driver) *Client {
 	return &Client{driver: driver, log: 1, m: &sync.Mutex{}}
 }
 
-// Del the value of a key.
+// Del the value of a key. If the key does not exist, an error wrapping ErrNotExist is returned.
 func (e *Client) Del(k string) error {
 	e.m.Lock()
 	defer e.m.Unlock()
-	return e.driver.Del(k)
+	if err := e.driver.Del(k); err != nil {
+		return &OpError{Op: "del", Key: k, Err: err}
+	}
+	return nil
 }
 
-// GetDecode get the decoded value of a key.
+// GetDecode get the decoded value of a key. If the value can not be decoded, a *DecodeError is returned.
 func (e *Client) GetDecode(k string, v any) error {
 	b, err := e.Get(k)
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(b, v)
+	if err := json.Unmarshal(b, v); err != nil {
+		return &DecodeError{Key: k, Err: err}
+	}
+	return nil
 }
 
 // GetFloat32 get the float32 value of a key.
@@ -276,13 +370,29 @@ func (e *Client) GetUint(k string) (uint, error) {
 func (e *Client) Get(k string) ([]byte, error) {
 	e.m.Lock()
 	defer e.m.Unlock()
-	return e.driver.Get(k)
+	v, err := e.driver.Get(k)
+	if err != nil {
+		return nil, &OpError{Op: "get", Key: k, Err: err}
+	}
+	return v, nil
 }
 
-// Has determine if a key exists.
+// Has determine if a key exists. It returns false if any error is encountered, use HasErr to tell them apart.
 func (e *Client) Has(k string) bool {
+	b, err := e.HasErr(k)
+	return err == nil && b
+}
+
+// HasErr determine if a key exists. A missing key is not an error, any other error encountered is returned.
+func (e *Client) HasErr(k string) (bool, error) {
 	_, err := e.Get(k)
-	return err == nil
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, ErrNotExist) {
+		return false, nil
+	}
+	return false, err
 }
 
 // Log set the log level.
@@ -290,17 +400,24 @@ func (e *Client) Log(l int) {
 	e.log = l
 }
 
-// Nil determine if a key emptys.
+// Nil determine if a key emptys. It returns false if any error other than a missing key is encountered, use NilErr to
+// tell them apart.
 func (e *Client) Nil(k string) bool {
-	_, err := e.Get(k)
-	return err != nil
+	b, err := e.NilErr(k)
+	return err == nil && b
+}
+
+// NilErr determine if a key emptys. A missing key is not an error, any other error encountered is returned.
+func (e *Client) NilErr(k string) (bool, error) {
+	b, err := e.HasErr(k)
+	return !b && err == nil, err
 }
 
-// SetEncode set the encoded value of a key.
+// SetEncode set the encoded value of a key. If the value can not be encoded, an *EncodeError is returned.
 func (e *Client) SetEncode(k string, v any) error {
 	b, err := json.Marshal(v)
 	if err != nil {
-		return err
+		return &EncodeError{Key: k, Err: err}
 	}
 	return e.Set(k, b)
 }
@@ -312,7 +429,10 @@ func (e *Client) Set(k string, v []byte) error {
 	if e.log != 0 {
 		log.Println("acdb: set", k, string(v))
 	}
-	return e.driver.Set(k, v)
+	if err := e.driver.Set(k, v); err != nil {
+		return &OpError{Op: "set", Key: k, Err: err}
+	}
+	return nil
 }
 
 // Mem returns a concurrency-safety Client with MemDriver.
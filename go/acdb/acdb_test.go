@@ -1,11 +1,19 @@
 package acdb
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 )
 
 func TestClient(t *testing.T) {
-	for _, client := range []*Client{Mem(), Doc(t.TempDir()), Lru(4), Map(t.TempDir())} {
+	for _, client := range []*Client{Mem(), Doc(t.TempDir()), Lru(4), Map(t.TempDir()), Bpt(filepath.Join(t.TempDir(), "bpt")).Client} {
 		client.Log(0)
 		client.SetEncode("n", 1)
 		n, err := client.GetInt("n")
@@ -19,3 +27,174 @@ func TestClient(t *testing.T) {
 		}
 	}
 }
+
+func TestClientError(t *testing.T) {
+	for _, client := range []*Client{Mem(), Doc(t.TempDir()), Lru(4), Map(t.TempDir()), Bpt(filepath.Join(t.TempDir(), "bpt")).Client} {
+		client.Log(0)
+		if _, err := client.Get("k"); !errors.Is(err, ErrNotExist) || !errors.Is(err, fs.ErrNotExist) {
+			t.FailNow()
+		}
+		if err := client.Del("k"); !errors.Is(err, ErrNotExist) {
+			t.FailNow()
+		}
+		if b, err := client.HasErr("k"); b || err != nil {
+			t.FailNow()
+		}
+		if b, err := client.NilErr("k"); !b || err != nil {
+			t.FailNow()
+		}
+		client.SetEncode("k", "Hello World!")
+		if b, err := client.HasErr("k"); !b || err != nil {
+			t.FailNow()
+		}
+		var derr *DecodeError
+		if _, err := client.GetInt("k"); !errors.As(err, &derr) || derr.Key != "k" {
+			t.FailNow()
+		}
+		var oerr *OpError
+		if err := client.Del("k"); err != nil {
+			t.FailNow()
+		}
+		if err := client.Del("k"); !errors.As(err, &oerr) || oerr.Op != "del" || oerr.Key != "k" {
+			t.FailNow()
+		}
+		var eerr *EncodeError
+		if err := client.SetEncode("k", func() {}); !errors.As(err, &eerr) || eerr.Key != "k" {
+			t.FailNow()
+		}
+	}
+}
+
+func TestBptDriver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bpt")
+	driver, err := NewBptDriver(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for i := range 4096 {
+		keys = append(keys, fmt.Sprintf("%08d", i))
+	}
+	rand.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+	for _, k := range keys {
+		if err := driver.Set(k, bytes.Repeat([]byte(k), 8)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, k := range keys[:2048] {
+		if err := driver.Del(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	driver.Set("huge", bytes.Repeat([]byte{0xff}, 3*bptPageSize))
+	driver.Close()
+	driver, err = NewBptDriver(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+	for _, k := range keys[:2048] {
+		if _, err := driver.Get(k); !errors.Is(err, ErrNotExist) {
+			t.FailNow()
+		}
+	}
+	for _, k := range keys[2048:] {
+		v, err := driver.Get(k)
+		if err != nil || !bytes.Equal(v, bytes.Repeat([]byte(k), 8)) {
+			t.FailNow()
+		}
+	}
+	if v, err := driver.Get("huge"); err != nil || len(v) != 3*bptPageSize {
+		t.FailNow()
+	}
+	live := slices.Sorted(slices.Values(keys[2048:]))
+	scan := []string{}
+	driver.Ascend("", func(k string, v []byte) bool {
+		scan = append(scan, k)
+		return true
+	})
+	if !slices.Equal(scan[:len(scan)-1], live) || scan[len(scan)-1] != "huge" {
+		t.FailNow()
+	}
+}
+
+func TestBptDriverRecover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bpt")
+	driver, err := NewBptDriver(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver.Set("a", []byte("1"))
+	driver.Set("b", []byte("2"))
+	txid := driver.meta.txid
+	driver.Close()
+	// Simulate a torn write of the latest meta page.
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte{0x00}, int64(txid%2)*bptPageSize+40)
+	f.Close()
+	driver, err = NewBptDriver(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+	if v, err := driver.Get("a"); err != nil || string(v) != "1" {
+		t.FailNow()
+	}
+	if _, err := driver.Get("b"); !errors.Is(err, ErrNotExist) {
+		t.FailNow()
+	}
+	if err := driver.Set("c", []byte("3")); err != nil {
+		t.FailNow()
+	}
+}
+
+func TestBptDriverInit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bpt")
+	// Simulate a crash of init after the root is written but before the meta page is.
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt((&bptNode{leaf: true}).encode(), 2*bptPageSize)
+	f.Close()
+	driver, err := NewBptDriver(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.Set("a", []byte("1")); err != nil {
+		t.FailNow()
+	}
+	driver.Close()
+	// A file that is not a fresh one is not formatted again.
+	os.WriteFile(path, bytes.Repeat([]byte{0xff}, 4*bptPageSize), 0644)
+	if _, err := NewBptDriver(path); !errors.Is(err, errBptCorrupt) {
+		t.FailNow()
+	}
+}
+
+func TestBptClient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bpt")
+	client := Bpt(path)
+	client.Log(0)
+	for _, k := range []string{"c", "a", "b"} {
+		client.SetEncode(k, k)
+	}
+	scan := []string{}
+	if err := client.Ascend("b", func(k string, v []byte) bool {
+		scan = append(scan, k)
+		return true
+	}); err != nil || !slices.Equal(scan, []string{"b", "c"}) {
+		t.FailNow()
+	}
+	if err := client.Close(); err != nil {
+		t.FailNow()
+	}
+	client = Bpt(path)
+	defer client.Close()
+	if s, err := client.GetString("a"); err != nil || s != "a" {
+		t.FailNow()
+	}
+}
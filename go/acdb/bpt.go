@@ -0,0 +1,699 @@
+package acdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sync"
+)
+
+// A single file, page based B+tree storage driver.
+//
+// The file is divided into pages of bptPageSize bytes. Page 0 and page 1 hold two copies of the meta data, the rest of
+// the file holds tree nodes. A node occupies one page, or a run of contiguous pages if it does not fit in one.
+//
+// Updates are copy-on-write: a node is never modified in place. Every Set or Del writes the changed nodes on the path
+// from the leaf to the root into free pages, syncs them to disk, and then commits by writing a new meta page that points
+// to the new root. The two meta pages are written alternately and carry a transaction id and a checksum, so a crash at
+// any point leaves at least one valid meta page that references a complete tree. A file whose creation was interrupted
+// has no valid meta page yet, and is formatted again when opened. Pages that are unreachable from the
+// chosen root are reclaimed when the file is opened.
+
+const (
+	bptPageSize = 4096
+	bptMagic    = 0x0074706262646361 // "acdbbpt\x00"
+	bptVersion  = 1
+	bptHeadSize = 16
+	bptLeaf     = 1
+	bptBranch   = 2
+)
+
+// Meta page layout, all integers are little endian:
+//
+//	[ 0: 8] magic
+//	[ 8:12] version
+//	[12:16] page size
+//	[16:24] root page id
+//	[24:32] number of pages in use, i.e. the high-water mark of the file
+//	[32:40] transaction id
+//	[40:48] fnv-1a checksum of [0:40]
+type bptMeta struct {
+	root  uint64
+	npage uint64
+	txid  uint64
+}
+
+func (m *bptMeta) encode() []byte {
+	buf := make([]byte, bptPageSize)
+	binary.LittleEndian.PutUint64(buf[0:8], bptMagic)
+	binary.LittleEndian.PutUint32(buf[8:12], bptVersion)
+	binary.LittleEndian.PutUint32(buf[12:16], bptPageSize)
+	binary.LittleEndian.PutUint64(buf[16:24], m.root)
+	binary.LittleEndian.PutUint64(buf[24:32], m.npage)
+	binary.LittleEndian.PutUint64(buf[32:40], m.txid)
+	binary.LittleEndian.PutUint64(buf[40:48], bptSum(buf[0:40]))
+	return buf
+}
+
+func (m *bptMeta) decode(buf []byte) bool {
+	if binary.LittleEndian.Uint64(buf[0:8]) != bptMagic {
+		return false
+	}
+	if binary.LittleEndian.Uint32(buf[8:12]) != bptVersion {
+		return false
+	}
+	if binary.LittleEndian.Uint32(buf[12:16]) != bptPageSize {
+		return false
+	}
+	if binary.LittleEndian.Uint64(buf[40:48]) != bptSum(buf[0:40]) {
+		return false
+	}
+	m.root = binary.LittleEndian.Uint64(buf[16:24])
+	m.npage = binary.LittleEndian.Uint64(buf[24:32])
+	m.txid = binary.LittleEndian.Uint64(buf[32:40])
+	return true
+}
+
+// Function bptSum computes the checksum of a meta page.
+func bptSum(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
+
+// Node page layout, all integers are little endian:
+//
+//	[ 0: 1] node type, bptLeaf or bptBranch
+//	[ 4: 8] number of entries
+//	[ 8:12] number of pages the node occupies
+//	[16:  ] entries
+//
+// A leaf entry is uvarint(len(key)) uvarint(len(val)) key val. A branch entry is uvarint(len(key)) uint64(child) key,
+// where key is the smallest key in the subtree of child.
+type bptNode struct {
+	id    uint64
+	pages uint64
+	leaf  bool
+	keys  [][]byte
+	vals  [][]byte
+	kids  []uint64
+}
+
+// Function entrySize returns the encoded size of the i-th entry of n.
+func (n *bptNode) entrySize(i int) int {
+	if n.leaf {
+		return bptUvarintSize(len(n.keys[i])) + bptUvarintSize(len(n.vals[i])) + len(n.keys[i]) + len(n.vals[i])
+	}
+	return bptUvarintSize(len(n.keys[i])) + 8 + len(n.keys[i])
+}
+
+func (n *bptNode) size() int {
+	s := bptHeadSize
+	for i := range n.keys {
+		s += n.entrySize(i)
+	}
+	return s
+}
+
+// Function search returns the index of the first key that is greater than or equal to k, and whether it is equal.
+func (n *bptNode) search(k []byte) (int, bool) {
+	return slices.BinarySearchFunc(n.keys, k, bytes.Compare)
+}
+
+// Function child returns the index of the child whose subtree may contain k.
+func (n *bptNode) child(k []byte) int {
+	i, ok := n.search(k)
+	if ok {
+		return i
+	}
+	return max(0, i-1)
+}
+
+func (n *bptNode) encode() []byte {
+	size := n.size()
+	pages := (size + bptPageSize - 1) / bptPageSize
+	buf := make([]byte, pages*bptPageSize)
+	if n.leaf {
+		buf[0] = bptLeaf
+	} else {
+		buf[0] = bptBranch
+	}
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(len(n.keys)))
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(pages))
+	p := bptHeadSize
+	for i, k := range n.keys {
+		p += binary.PutUvarint(buf[p:], uint64(len(k)))
+		if n.leaf {
+			p += binary.PutUvarint(buf[p:], uint64(len(n.vals[i])))
+		} else {
+			binary.LittleEndian.PutUint64(buf[p:], n.kids[i])
+			p += 8
+		}
+		p += copy(buf[p:], k)
+		if n.leaf {
+			p += copy(buf[p:], n.vals[i])
+		}
+	}
+	return buf
+}
+
+func (n *bptNode) decode(buf []byte) error {
+	switch buf[0] {
+	case bptLeaf:
+		n.leaf = true
+	case bptBranch:
+		n.leaf = false
+	default:
+		return errBptCorrupt
+	}
+	count := int(binary.LittleEndian.Uint32(buf[4:8]))
+	n.keys = make([][]byte, 0, count)
+	if n.leaf {
+		n.vals = make([][]byte, 0, count)
+	} else {
+		n.kids = make([]uint64, 0, count)
+	}
+	p := bptHeadSize
+	for range count {
+		klen, m := binary.Uvarint(buf[p:])
+		if m <= 0 {
+			return errBptCorrupt
+		}
+		p += m
+		var vlen uint64
+		if n.leaf {
+			vlen, m = binary.Uvarint(buf[p:])
+			if m <= 0 {
+				return errBptCorrupt
+			}
+			p += m
+		} else {
+			if p+8 > len(buf) {
+				return errBptCorrupt
+			}
+			n.kids = append(n.kids, binary.LittleEndian.Uint64(buf[p:]))
+			p += 8
+		}
+		if uint64(len(buf)-p) < klen+vlen {
+			return errBptCorrupt
+		}
+		n.keys = append(n.keys, buf[p:p+int(klen)])
+		p += int(klen)
+		if n.leaf {
+			n.vals = append(n.vals, buf[p:p+int(vlen)])
+			p += int(vlen)
+		}
+	}
+	return nil
+}
+
+// Function bptUvarintSize returns the number of bytes needed to encode n as a uvarint.
+func bptUvarintSize(n int) int {
+	s := 1
+	for n >= 0x80 {
+		n >>= 7
+		s++
+	}
+	return s
+}
+
+var errBptCorrupt = errors.New("acdb: bpt: corrupt file")
+
+// BptDriver stores all keys in a single file organized as a copy-on-write B+tree. Keys are kept in lexicographical byte
+// order, which makes ordered iteration possible with Ascend. Every Set and Del is durable when it returns, and a crash
+// never leaves the file in an inconsistent state. The file must not be opened by more than one BptDriver at a time.
+type BptDriver struct {
+	file *os.File
+	free []uint64
+	meta bptMeta
+	m    *sync.Mutex
+	// Pages released by the running transaction. They may still be referenced by the previous meta page, so they are
+	// only reused after the transaction is committed.
+	pend []uint64
+}
+
+// NewBptDriver opens or creates the B+tree file at path and returns a BptDriver.
+func NewBptDriver(path string) (*BptDriver, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	d := &BptDriver{file: f, m: &sync.Mutex{}}
+	info, err := f.Stat()
+	if err == nil && info.Size() == 0 {
+		err = d.init()
+	} else if err == nil {
+		err = d.load()
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Function init formats an empty file. The root is an empty leaf at page 2. The meta page is written last, and only
+// to page 0, so that a crash in between leaves page 1 zeroed, which load recognizes as an unfinished init.
+func (d *BptDriver) init() error {
+	root := &bptNode{leaf: true}
+	if _, err := d.file.WriteAt(root.encode(), 2*bptPageSize); err != nil {
+		return err
+	}
+	if err := d.file.Sync(); err != nil {
+		return err
+	}
+	d.meta = bptMeta{root: 2, npage: 3, txid: 0}
+	if _, err := d.file.WriteAt(d.meta.encode(), 0); err != nil {
+		return err
+	}
+	return d.file.Sync()
+}
+
+// Function load picks the newest valid meta page and rebuilds the free list from the pages reachable from its root.
+func (d *BptDriver) load() error {
+	buf := make([]byte, bptPageSize)
+	ok := false
+	for i := range 2 {
+		clear(buf)
+		if _, err := d.file.ReadAt(buf, int64(i)*bptPageSize); err != nil && err != io.EOF {
+			return err
+		}
+		m := bptMeta{}
+		if m.decode(buf) && (!ok || m.txid > d.meta.txid) {
+			d.meta = m
+			ok = true
+		}
+	}
+	if !ok {
+		// Page 1 is first written by the first commit, after page 0 is synced. If it is still zeroed and the file is no
+		// larger than a fresh one, init was interrupted and the tree is empty anyway.
+		info, err := d.file.Stat()
+		if err != nil {
+			return err
+		}
+		if info.Size() <= 3*bptPageSize && !slices.ContainsFunc(buf, func(b byte) bool { return b != 0 }) {
+			return d.init()
+		}
+		return errBptCorrupt
+	}
+	used := make([]bool, d.meta.npage)
+	used[0] = true
+	used[1] = true
+	if err := d.mark(d.meta.root, used); err != nil {
+		return err
+	}
+	for i, u := range used {
+		if !u {
+			d.free = append(d.free, uint64(i))
+		}
+	}
+	return nil
+}
+
+// Function mark flags every page reachable from node id as used.
+func (d *BptDriver) mark(id uint64, used []bool) error {
+	n, err := d.read(id)
+	if err != nil {
+		return err
+	}
+	if n.id+n.pages > uint64(len(used)) {
+		return errBptCorrupt
+	}
+	for i := range n.pages {
+		if used[n.id+i] {
+			return errBptCorrupt
+		}
+		used[n.id+i] = true
+	}
+	for _, c := range n.kids {
+		if err := d.mark(c, used); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function read loads the node stored at page id.
+func (d *BptDriver) read(id uint64) (*bptNode, error) {
+	if id < 2 || id >= d.meta.npage {
+		return nil, errBptCorrupt
+	}
+	buf := make([]byte, bptPageSize)
+	if _, err := d.file.ReadAt(buf, int64(id)*bptPageSize); err != nil {
+		return nil, err
+	}
+	pages := uint64(binary.LittleEndian.Uint32(buf[8:12]))
+	if pages == 0 || id+pages > d.meta.npage {
+		return nil, errBptCorrupt
+	}
+	if pages > 1 {
+		buf = slices.Grow(buf, int(pages-1)*bptPageSize)[:pages*bptPageSize]
+		if _, err := d.file.ReadAt(buf[bptPageSize:], int64(id+1)*bptPageSize); err != nil {
+			return nil, err
+		}
+	}
+	n := &bptNode{id: id, pages: pages}
+	if err := n.decode(buf); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Function alloc returns the first page id of n contiguous free pages, growing the file if necessary.
+func (d *BptDriver) alloc(n uint64) uint64 {
+	run := uint64(0)
+	for i, id := range d.free {
+		if i > 0 && d.free[i-1]+1 == id {
+			run++
+		} else {
+			run = 1
+		}
+		if run == n {
+			d.free = slices.Delete(d.free, i+1-int(n), i+1)
+			return id + 1 - n
+		}
+	}
+	id := d.meta.npage
+	d.meta.npage += n
+	return id
+}
+
+// Function release marks the pages of n as free once the running transaction commits.
+func (d *BptDriver) release(n *bptNode) {
+	for i := range n.pages {
+		d.pend = append(d.pend, n.id+i)
+	}
+}
+
+// Function write stores n into newly allocated pages. If n is too large for a single page it is split into several
+// nodes, each holding at least two entries when possible. It returns the nodes that were written.
+func (d *BptDriver) write(n *bptNode) ([]*bptNode, error) {
+	part := []*bptNode{}
+	l := 0
+	for l < len(n.keys) || len(part) == 0 {
+		r := l
+		s := bptHeadSize
+		for r < len(n.keys) {
+			e := n.entrySize(r)
+			if r-l >= 2 && s+e > bptPageSize {
+				break
+			}
+			s += e
+			r++
+		}
+		if len(n.keys)-r == 1 {
+			r++
+		}
+		p := &bptNode{leaf: n.leaf, keys: n.keys[l:r]}
+		if n.leaf {
+			p.vals = n.vals[l:r]
+		} else {
+			p.kids = n.kids[l:r]
+		}
+		part = append(part, p)
+		l = r
+	}
+	for _, p := range part {
+		buf := p.encode()
+		p.pages = uint64(len(buf) / bptPageSize)
+		p.id = d.alloc(p.pages)
+		if _, err := d.file.WriteAt(buf, int64(p.id)*bptPageSize); err != nil {
+			return nil, err
+		}
+	}
+	return part, nil
+}
+
+// Function link replaces the i-th entry of branch n with the given children.
+func (n *bptNode) link(i int, j int, part []*bptNode) {
+	keys := make([][]byte, len(part))
+	kids := make([]uint64, len(part))
+	for x, p := range part {
+		keys[x] = p.keys[0]
+		kids[x] = p.id
+	}
+	n.keys = slices.Replace(n.keys, i, j, keys...)
+	n.kids = slices.Replace(n.kids, i, j, kids...)
+}
+
+// Function put inserts or replaces k in the subtree rooted at n. Node n is modified in memory only.
+func (d *BptDriver) put(n *bptNode, k []byte, v []byte) error {
+	if n.leaf {
+		i, ok := n.search(k)
+		if ok {
+			n.vals[i] = v
+		} else {
+			n.keys = slices.Insert(n.keys, i, k)
+			n.vals = slices.Insert(n.vals, i, v)
+		}
+		return nil
+	}
+	i := n.child(k)
+	c, err := d.read(n.kids[i])
+	if err != nil {
+		return err
+	}
+	if err := d.put(c, k, v); err != nil {
+		return err
+	}
+	d.release(c)
+	part, err := d.write(c)
+	if err != nil {
+		return err
+	}
+	n.link(i, i+1, part)
+	return nil
+}
+
+// Function del removes k from the subtree rooted at n. Node n is modified in memory only. Children that become small
+// are merged with a sibling, children that become empty are dropped.
+func (d *BptDriver) del(n *bptNode, k []byte) error {
+	if n.leaf {
+		i, ok := n.search(k)
+		if !ok {
+			return ErrNotExist
+		}
+		n.keys = slices.Delete(n.keys, i, i+1)
+		n.vals = slices.Delete(n.vals, i, i+1)
+		return nil
+	}
+	i := n.child(k)
+	c, err := d.read(n.kids[i])
+	if err != nil {
+		return err
+	}
+	if err := d.del(c, k); err != nil {
+		return err
+	}
+	d.release(c)
+	if len(c.keys) == 0 {
+		n.keys = slices.Delete(n.keys, i, i+1)
+		n.kids = slices.Delete(n.kids, i, i+1)
+		return nil
+	}
+	j := i
+	if c.size() < bptPageSize/4 && len(n.kids) > 1 {
+		// Merge with the right sibling, or with the left one if c is the last child.
+		l, r := c, c
+		if i+1 < len(n.kids) {
+			j = i + 1
+			r, err = d.read(n.kids[j])
+		} else {
+			i = i - 1
+			l, err = d.read(n.kids[i])
+		}
+		if err != nil {
+			return err
+		}
+		if l == c {
+			d.release(r)
+		} else {
+			d.release(l)
+		}
+		c = &bptNode{
+			leaf: c.leaf,
+			keys: slices.Concat(l.keys, r.keys),
+			vals: slices.Concat(l.vals, r.vals),
+			kids: slices.Concat(l.kids, r.kids),
+		}
+	}
+	part, err := d.write(c)
+	if err != nil {
+		return err
+	}
+	n.link(i, j+1, part)
+	return nil
+}
+
+// Function update runs f against the root and commits the result. On failure the in-memory state is rolled back, the
+// file itself is untouched since live pages are never overwritten.
+func (d *BptDriver) update(f func(root *bptNode) error) error {
+	meta := d.meta
+	free := slices.Clone(d.free)
+	err := d.commit(f)
+	if err != nil {
+		d.meta = meta
+		d.free = free
+	}
+	d.pend = d.pend[:0]
+	return err
+}
+
+func (d *BptDriver) commit(f func(root *bptNode) error) error {
+	root, err := d.read(d.meta.root)
+	if err != nil {
+		return err
+	}
+	if err := f(root); err != nil {
+		return err
+	}
+	d.release(root)
+	// Collapse branches with a single child.
+	for !root.leaf && len(root.kids) == 1 {
+		c, err := d.read(root.kids[0])
+		if err != nil {
+			return err
+		}
+		d.release(c)
+		root = c
+	}
+	if !root.leaf && len(root.kids) == 0 {
+		root = &bptNode{leaf: true}
+	}
+	part, err := d.write(root)
+	if err != nil {
+		return err
+	}
+	for len(part) > 1 {
+		root = &bptNode{}
+		root.link(0, 0, part)
+		part, err = d.write(root)
+		if err != nil {
+			return err
+		}
+	}
+	if err := d.file.Sync(); err != nil {
+		return err
+	}
+	d.meta.root = part[0].id
+	d.meta.txid++
+	if _, err := d.file.WriteAt(d.meta.encode(), int64(d.meta.txid%2)*bptPageSize); err != nil {
+		return err
+	}
+	if err := d.file.Sync(); err != nil {
+		return err
+	}
+	d.free = append(d.free, d.pend...)
+	slices.Sort(d.free)
+	return nil
+}
+
+// Ascend calls fn for every key greater than or equal to pivot in ascending order, until fn returns false. The value
+// passed to fn must not be retained. Fn must not call other methods of the driver.
+func (d *BptDriver) Ascend(pivot string, fn func(k string, v []byte) bool) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+	_, err := d.ascend(d.meta.root, []byte(pivot), fn)
+	return err
+}
+
+func (d *BptDriver) ascend(id uint64, pivot []byte, fn func(k string, v []byte) bool) (bool, error) {
+	n, err := d.read(id)
+	if err != nil {
+		return false, err
+	}
+	if n.leaf {
+		i, _ := n.search(pivot)
+		for ; i < len(n.keys); i++ {
+			if !fn(string(n.keys[i]), n.vals[i]) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	for i := n.child(pivot); i < len(n.kids); i++ {
+		ok, err := d.ascend(n.kids[i], pivot, fn)
+		if !ok || err != nil {
+			return ok, err
+		}
+	}
+	return true, nil
+}
+
+// Close closes the underlying file.
+func (d *BptDriver) Close() error {
+	d.m.Lock()
+	defer d.m.Unlock()
+	return d.file.Close()
+}
+
+// Del the value of a key.
+func (d *BptDriver) Del(k string) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+	return d.update(func(root *bptNode) error {
+		return d.del(root, []byte(k))
+	})
+}
+
+// Get the value of a key.
+func (d *BptDriver) Get(k string) ([]byte, error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	key := []byte(k)
+	n, err := d.read(d.meta.root)
+	for err == nil && !n.leaf {
+		n, err = d.read(n.kids[n.child(key)])
+	}
+	if err != nil {
+		return nil, err
+	}
+	i, ok := n.search(key)
+	if !ok {
+		return nil, ErrNotExist
+	}
+	return n.vals[i], nil
+}
+
+// Set the value of a key.
+func (d *BptDriver) Set(k string, v []byte) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+	return d.update(func(root *bptNode) error {
+		return d.put(root, []byte(k), slices.Clone(v))
+	})
+}
+
+// BptClient is a Client with a BptDriver. Unlike the other clients it holds an open file, and must be closed.
+type BptClient struct {
+	*Client
+	driver *BptDriver
+}
+
+// Ascend calls fn for every key greater than or equal to pivot in ascending order, until fn returns false. The value
+// passed to fn must not be retained. Fn must not call other methods of the client.
+func (e *BptClient) Ascend(pivot string, fn func(k string, v []byte) bool) error {
+	if err := e.driver.Ascend(pivot, fn); err != nil {
+		return &OpError{Op: "ascend", Key: pivot, Err: err}
+	}
+	return nil
+}
+
+// Close closes the underlying file.
+func (e *BptClient) Close() error {
+	return e.driver.Close()
+}
+
+// Bpt returns a concurrency-safety Client with BptDriver.
+func Bpt(path string) *BptClient {
+	d, err := NewBptDriver(path)
+	if err != nil {
+		log.Panicln("acdb:", err)
+	}
+	return &BptClient{Client: NewClient(d), driver: d}
+}
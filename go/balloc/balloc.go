@@ -1,9 +1,17 @@
 package balloc
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math"
 	"math/bits"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
@@ -16,16 +24,26 @@ import (
 
 // Algorithm implements the core buddy allocation algorithm. It manages a memory pool by maintaining free lists for
 // different block sizes.
+//
+// The free lists are doubly linked and intrusive: the first word of a free block holds the offset of the next free
+// block of the same order, the second word holds the offset of the previous one, -1 marks the end of a list. Together
+// with FreeMap this makes finding and unlinking a free buddy O(1), so Close is O(MaxOrder).
 type Algorithm struct {
 	// FreeList maintains linked lists of free blocks for each order (size). Each index represents blocks of size
 	// MinBlock * 2^index.
 	FreeList []int
+	// FreeMap is a side table of free blocks, indexed by Offset/MinBlock. A value of 0 means that no free block starts
+	// at that offset, otherwise the value is the order of the block plus one.
+	FreeMap []uint8
 	// MaxOrder is the maximum order (size class) available, calculated as log2(MaxTotal/MinBlock).
 	MaxOrder int
 	// MaxTotal is the total size of the memory pool in bytes.
 	MaxTotal int
 	// MinBlock is the minimum allocation unit size in bytes.
 	MinBlock int
+	// OrderMap is a side table of live blocks, indexed by Offset/MinBlock. A value of 0 means that no live block starts
+	// at that offset, otherwise the value is the order of the block plus one.
+	OrderMap []uint8
 	// PreAlloc is the pre-allocated memory pool that the allocator manages.
 	PreAlloc []byte
 }
@@ -43,30 +61,63 @@ type Blockinfo struct {
 // MinBlock * 2^order. Returns a Blockinfo with Offset=-1 if allocation fails. If no block of the requested order is
 // available, it recursively splits larger blocks.
 func (b *Algorithm) Alloc(order int) Blockinfo {
-	if order > b.MaxOrder {
+	if order < 0 {
+		return Blockinfo{Offset: -1, Length: 0}
+	}
+	blockOffset := b.alloc(order)
+	if blockOffset == -1 {
 		return Blockinfo{Offset: -1, Length: 0}
 	}
-	blockSize := b.MinBlock << order
+	b.OrderMap[blockOffset/b.MinBlock] = uint8(order + 1)
+	return Blockinfo{
+		Offset: blockOffset,
+		Length: b.MinBlock << order,
+	}
+}
+
+// Function alloc takes a free block of the specified order out of the free lists and returns its offset, or -1.
+func (b *Algorithm) alloc(order int) int {
+	if order > b.MaxOrder {
+		return -1
+	}
 	if b.FreeList[order] != -1 {
 		blockOffset := b.FreeList[order]
-		b.FreeList[order] = ildr(b.PreAlloc, blockOffset)
-		return Blockinfo{
-			Offset: blockOffset,
-			Length: blockSize,
-		}
+		b.unlink(blockOffset, order)
+		return blockOffset
 	}
-	block := b.Alloc(order + 1)
-	blockOffset := block.Offset
+	blockOffset := b.alloc(order + 1)
 	if blockOffset == -1 {
-		return block
+		return -1
 	}
-	buddyOffset := blockOffset + blockSize
-	istr(b.PreAlloc, buddyOffset, -1)
-	b.FreeList[order] = buddyOffset
-	return Blockinfo{
-		Offset: block.Offset,
-		Length: blockSize,
+	b.link(blockOffset+b.MinBlock<<order, order)
+	return blockOffset
+}
+
+// Function link pushes a free block to the front of the free list of its order.
+func (b *Algorithm) link(blockOffset int, order int) {
+	next := b.FreeList[order]
+	b.store(blockOffset, 0, next)
+	b.store(blockOffset, 1, -1)
+	if next != -1 {
+		b.store(next, 1, blockOffset)
+	}
+	b.FreeList[order] = blockOffset
+	b.FreeMap[blockOffset/b.MinBlock] = uint8(order + 1)
+}
+
+// Function unlink removes a free block from the free list of its order.
+func (b *Algorithm) unlink(blockOffset int, order int) {
+	next := b.word(blockOffset, 0)
+	prev := b.word(blockOffset, 1)
+	if prev == -1 {
+		b.FreeList[order] = next
+	} else {
+		b.store(prev, 0, next)
+	}
+	if next != -1 {
+		b.store(next, 1, prev)
 	}
+	b.FreeMap[blockOffset/b.MinBlock] = 0
 }
 
 // Avail calculates the total available memory in the pool by summing the sizes of all free blocks across all orders.
@@ -79,87 +130,926 @@ func (b *Algorithm) Avail() int {
 				break
 			}
 			s += b.MinBlock << order
-			n = ildr(b.PreAlloc, n)
+			n = b.word(n, 0)
 		}
 	}
 	return s
 }
 
 // Close frees a memory block and attempts to merge it with its buddy. This implements the buddy merging logic: when
-// both buddies are free, they are merged into a larger block at the next order level.
-func (b *Algorithm) Close(block Blockinfo) {
-	order := log2(b.MinBlock, block.Length)
-	blockOffset := block.Offset
-	blockIdx := blockOffset / block.Length
-	buddyIdx := blockIdx ^ 1
-	buddyOffset := buddyIdx * block.Length
-	buddy := Blockinfo{Offset: buddyOffset, Length: block.Length}
-	upper := Blockinfo{Offset: min(blockOffset, buddyOffset), Length: block.Length * 2}
-	n := b.FreeList[order]
-	m := 0
-	for {
-		if n == -1 {
-			istr(b.PreAlloc, blockOffset, b.FreeList[order])
-			b.FreeList[order] = blockOffset
+// both buddies are free, they are merged into a larger block at the next order level. The block must be a live block
+// returned by Alloc, otherwise an error is returned and the free lists are left untouched.
+func (b *Algorithm) Close(block Blockinfo) error {
+	order, err := b.Lookup(block.Offset)
+	if err != nil {
+		return err
+	}
+	if block.Length != b.MinBlock<<order {
+		return fmt.Errorf("%w: offset %d length %d, block length %d", ErrMismatch, block.Offset, block.Length,
+			b.MinBlock<<order)
+	}
+	b.OrderMap[block.Offset/b.MinBlock] = 0
+	b.close(block.Offset, order)
+	return nil
+}
+
+// Function close puts a block back into the free lists, merging it with its buddy as long as the buddy is free.
+func (b *Algorithm) close(blockOffset int, order int) {
+	for order < b.MaxOrder {
+		buddyOffset := blockOffset ^ b.MinBlock<<order
+		if int(b.FreeMap[buddyOffset/b.MinBlock]) != order+1 {
 			break
 		}
-		m = ildr(b.PreAlloc, n)
-		if n == buddy.Offset {
-			b.FreeList[order] = m
-			b.Close(upper)
-			break
+		b.unlink(buddyOffset, order)
+		blockOffset = min(blockOffset, buddyOffset)
+		order++
+	}
+	b.link(blockOffset, order)
+}
+
+// Resize changes the order of the live block at offset in place. Shrinking always succeeds, the upper halves of the
+// block are freed. Growing succeeds only if the block is aligned to the new size and all the buddies above it are
+// free, in which case they are merged into the block. It returns false and leaves the block untouched otherwise.
+func (b *Algorithm) Resize(offset int, order int, newOrder int) bool {
+	if newOrder < 0 || newOrder > b.MaxOrder {
+		return false
+	}
+	if newOrder < order {
+		for o := order - 1; o >= newOrder; o-- {
+			b.link(offset+b.MinBlock<<o, o)
 		}
-		if m == buddy.Offset {
-			istr(b.PreAlloc, n, ildr(b.PreAlloc, m))
-			b.Close(upper)
-			break
+	}
+	if newOrder > order {
+		if offset&(b.MinBlock<<newOrder-1) != 0 {
+			return false
+		}
+		for o := order; o < newOrder; o++ {
+			if int(b.FreeMap[(offset+b.MinBlock<<o)/b.MinBlock]) != o+1 {
+				return false
+			}
+		}
+		for o := order; o < newOrder; o++ {
+			b.unlink(offset+b.MinBlock<<o, o)
+		}
+	}
+	b.OrderMap[offset/b.MinBlock] = uint8(newOrder + 1)
+	return true
+}
+
+// Function carve shrinks the live block at offset to the block of newOrder at target, which lies inside it. The rest
+// of the block is freed.
+func (b *Algorithm) carve(offset int, order int, target int, newOrder int) {
+	b.OrderMap[offset/b.MinBlock] = 0
+	for o := order - 1; o >= newOrder; o-- {
+		if target >= offset+b.MinBlock<<o {
+			b.link(offset, o)
+			offset += b.MinBlock << o
+		} else {
+			b.link(offset+b.MinBlock<<o, o)
+		}
+	}
+	b.OrderMap[target/b.MinBlock] = uint8(newOrder + 1)
+}
+
+// Lookup returns the order of the live block that starts at offset. If there is no such block, the error tells whether
+// the offset points into the middle of a live block, or into free memory, which means the block has already been freed.
+func (b *Algorithm) Lookup(offset int) (int, error) {
+	if offset < 0 || offset >= b.MaxTotal {
+		return 0, fmt.Errorf("%w: offset %d", ErrForeign, offset)
+	}
+	if offset%b.MinBlock == 0 && b.OrderMap[offset/b.MinBlock] != 0 {
+		return int(b.OrderMap[offset/b.MinBlock]) - 1, nil
+	}
+	for order := 0; order <= b.MaxOrder; order++ {
+		blockOffset := offset &^ (b.MinBlock<<order - 1)
+		if int(b.OrderMap[blockOffset/b.MinBlock]) == order+1 {
+			return 0, fmt.Errorf("%w: offset %d inside block at offset %d order %d", ErrInterior, offset, blockOffset,
+				order)
+		}
+	}
+	return 0, fmt.Errorf("%w: offset %d", ErrDoubleFree, offset)
+}
+
+// Check verifies the consistency of the metadata. The free lists must be well formed and agree with FreeMap, every
+// block must be aligned to its size, blocks must not overlap and together cover the whole memory pool, and no two free
+// buddies may be left unmerged. The first inconsistency found is reported as an ErrCorrupt error. It takes time
+// proportional to the size of the pool, so it is meant for tests and debugging.
+func (b *Algorithm) Check() error {
+	// Order of the block that covers each unit plus one, or 0 if no block covers it yet.
+	used := make([]uint8, len(b.FreeMap))
+	cover := func(blockOffset int, order int, kind string) error {
+		if blockOffset < 0 || blockOffset >= b.MaxTotal {
+			return fmt.Errorf("%w: %s block at offset %d order %d is out of the pool", ErrCorrupt, kind, blockOffset,
+				order)
+		}
+		if blockOffset&(b.MinBlock<<order-1) != 0 {
+			return fmt.Errorf("%w: %s block at offset %d order %d is misaligned", ErrCorrupt, kind, blockOffset, order)
+		}
+		unit := blockOffset / b.MinBlock
+		for i := unit; i < unit+1<<order; i++ {
+			if used[i] != 0 {
+				return fmt.Errorf("%w: %s block at offset %d order %d overlaps offset %d", ErrCorrupt, kind,
+					blockOffset, order, i*b.MinBlock)
+			}
+			used[i] = uint8(order + 1)
+		}
+		return nil
+	}
+	free := 0
+	for order := 0; order <= b.MaxOrder; order++ {
+		prev := -1
+		for n := b.FreeList[order]; n != -1; n = b.word(n, 0) {
+			// The overlap check also catches cycles, since a block on a cycle is visited twice.
+			if err := cover(n, order, "free"); err != nil {
+				return err
+			}
+			if int(b.FreeMap[n/b.MinBlock]) != order+1 {
+				return fmt.Errorf("%w: free block at offset %d order %d has free map entry %d", ErrCorrupt, n, order,
+					b.FreeMap[n/b.MinBlock])
+			}
+			if b.word(n, 1) != prev {
+				return fmt.Errorf("%w: free block at offset %d order %d has prev %d, want %d", ErrCorrupt, n, order,
+					b.word(n, 1), prev)
+			}
+			if order < b.MaxOrder && int(b.FreeMap[(n^b.MinBlock<<order)/b.MinBlock]) == order+1 {
+				return fmt.Errorf("%w: free block at offset %d order %d has a free buddy", ErrCorrupt, n, order)
+			}
+			prev = n
+			free++
+		}
+	}
+	for i, e := range b.FreeMap {
+		if e != 0 {
+			free--
+		}
+		if e != 0 && used[i] != e {
+			return fmt.Errorf("%w: free map entry at offset %d is not on the free list of order %d", ErrCorrupt,
+				i*b.MinBlock, e-1)
+		}
+	}
+	if free != 0 {
+		return fmt.Errorf("%w: free map and free lists disagree", ErrCorrupt)
+	}
+	for i, e := range b.OrderMap {
+		if e == 0 {
+			continue
+		}
+		if int(e)-1 > b.MaxOrder {
+			return fmt.Errorf("%w: live block at offset %d has invalid order %d", ErrCorrupt, i*b.MinBlock, e-1)
+		}
+		if err := cover(i*b.MinBlock, int(e)-1, "live"); err != nil {
+			return err
 		}
-		n = m
 	}
+	for i, e := range used {
+		if e == 0 {
+			return fmt.Errorf("%w: offset %d is neither free nor live", ErrCorrupt, i*b.MinBlock)
+		}
+	}
+	return nil
 }
 
+// Errors returned by Close when the slice does not describe a live block.
+var (
+	// ErrDoubleFree is returned when the block has already been freed.
+	ErrDoubleFree = errors.New("balloc: double free")
+	// ErrEmpty is returned when the slice has no backing array, so its block can not be identified.
+	ErrEmpty = errors.New("balloc: empty slice")
+	// ErrForeign is returned when the offset is outside of the memory pool.
+	ErrForeign = errors.New("balloc: foreign slice")
+	// ErrInterior is returned when the slice starts in the middle of a live block.
+	ErrInterior = errors.New("balloc: interior pointer")
+	// ErrMismatch is returned when the length of the slice does not match the order of the block.
+	ErrMismatch = errors.New("balloc: length mismatch")
+	// ErrOverflow is returned when the canary bytes around an allocation have been overwritten.
+	ErrOverflow = errors.New("balloc: buffer overflow")
+)
+
+// ErrCorrupt is returned by Check when the metadata of a memory pool is inconsistent.
+var ErrCorrupt = errors.New("balloc: corrupted metadata")
+
+// ErrExhausted is returned when an allocation can not be satisfied from the memory pool.
+var ErrExhausted = errors.New("balloc: out of memory")
+
+// Policy decides what Allocator.Alloc does when the memory pool is exhausted.
+type Policy int
+
+const (
+	// PolicyHeap falls back to heap allocation. The memory is reclaimed by the garbage collector, Close ignores it.
+	PolicyHeap Policy = iota
+	// PolicyError returns nil, use TryAlloc to get an error instead.
+	PolicyError
+	// PolicyBlock blocks until enough memory is freed, see AllocContext. This turns the pool into a hard memory budget.
+	PolicyBlock
+)
+
+const (
+	// Size of the guard zone in front of each allocation in guard mode. The first 8 bytes store the requested size, the
+	// rest is filled with guardByte.
+	guardSize = 16
+	// Canary value written around allocations in guard mode.
+	guardByte = 0xa5
+)
+
 // Allocator is a thread-safe wrapper around the buddy allocation algorithm. It provides synchronized access to the
 // underlying Algorithm for concurrent use.
+//
+// The allocator starts with a single arena, Inner. When it is exhausted and MaxArena allows it, additional arenas of the
+// same size are created. An additional arena that has been completely free for longer than IdleTime is released, by a
+// timer, without waiting for the next call to the allocator.
 type Allocator struct {
-	Inner *Algorithm
-	Mutex *sync.Mutex
+	// Debug makes Close panic with diagnostics instead of returning an error when it is given an invalid slice. It also
+	// records the address of every heap fallback, so that any other foreign slice is reported. It must be set before the
+	// first call to Alloc.
+	Debug bool
+	// Guard surrounds every allocation with canary bytes, which are verified by Close to catch buffer overflows. It is a
+	// debugging aid that costs memory, and must be set before the first call to Alloc.
+	Guard bool
+	// IdleTime is how long an additional arena must stay completely free before it is released.
+	IdleTime time.Duration
+	Inner    *Algorithm
+	// MaxArena is the maximum number of arenas, including Inner. When all of them are exhausted, Policy applies.
+	MaxArena int
+	Mutex    *sync.Mutex
+	// Policy decides what Alloc does when all arenas are exhausted. The default is PolicyHeap.
+	Policy Policy
+	// Track records the call stack of every live allocation, see Leaks. It is a debugging aid that costs time and
+	// memory, allocations made before it is set are not tracked.
+	Track bool
+	extra []*arena
+	// Addresses of the heap fallbacks not closed yet, in debug mode.
+	fall map[uintptr]struct{}
+	// Number of allocations that fell back to the heap.
+	heap int
+	// Bytes in use in all arenas, and the high-water mark of it.
+	used int
+	peak int
+	// Releases the memory of the primary arena if it does not live on the Go heap.
+	unmap func() error
+	// Callers blocked in AllocContext, in FIFO order, and the number of them for readers that do not hold the lock.
+	wait   []*waiter
+	parked atomic.Int32
+	// Returns the memory cached by a front end such as Sharded, called without the lock before a caller blocks.
+	reclaim func()
+	// Live allocations in tracking mode, keyed by the address of their block.
+	live map[uintptr]*trace
+}
+
+// An arena is an additional buddy arena owned by an Allocator.
+type arena struct {
+	inner *Algorithm
+	// The time since the arena has been completely free, or the zero time if it is in use.
+	since time.Time
+	// Sweeps the arena once it has been free for IdleTime, so that it is released even if the allocator is not used.
+	sweep *time.Timer
 }
 
-// Alloc allocates a byte slice of the requested size from the memory pool. If the pool is exhausted, it falls back to
-// heap allocation. This method is thread-safe.
+// Alloc allocates a byte slice of the requested size from the memory pool. If all arenas are exhausted, the Policy of
+// the allocator decides: it falls back to heap allocation, returns nil, or blocks until enough memory is freed. Nil is
+// also returned if the policy is to block but the size can never be satisfied. The capacity of the returned slice is
+// limited to its block, so appending to it never overwrites neighbouring allocations. This method is thread-safe.
 func (b *Allocator) Alloc(size int) []byte {
+	if b.Policy == PolicyBlock {
+		r, _ := b.AllocContext(context.Background(), size)
+		return r
+	}
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+	r, _ := b.grab(size)
+	return r
+}
+
+// TryAlloc allocates a byte slice of the requested size from the memory pool. It never falls back to the heap nor
+// blocks, regardless of the Policy: if the memory can not be allocated right now, ErrExhausted is returned. This method
+// is thread-safe.
+func (b *Allocator) TryAlloc(size int) ([]byte, error) {
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+	if r := b.get(size); r != nil {
+		return r, nil
+	}
+	return nil, ErrExhausted
+}
+
+// AllocContext allocates a byte slice of the requested size from the memory pool, blocking until enough memory is
+// freed if the pool is exhausted. Blocked callers are served in FIFO order, and a caller never overtakes them. If ctx
+// is done first, its error is returned. ErrExhausted is returned right away if the size is larger than an arena. This
+// method is thread-safe.
+func (b *Allocator) AllocContext(ctx context.Context, size int) ([]byte, error) {
+	b.Mutex.Lock()
+	if r := b.get(size); r != nil {
+		b.Mutex.Unlock()
+		return r, nil
+	}
+	if b.order(size) > b.Inner.MaxOrder {
+		b.Mutex.Unlock()
+		return nil, ErrExhausted
+	}
+	w := &waiter{size: size, data: make(chan []byte, 1)}
+	if b.Track {
+		w.stack = callers()
+	}
+	b.wait = append(b.wait, w)
+	b.parked.Store(int32(len(b.wait)))
+	reclaim := b.reclaim
+	b.Mutex.Unlock()
+	if reclaim != nil {
+		// Memory held in the caches of a front end may satisfy the waiter, it is handed over by wake.
+		reclaim()
+	}
+	select {
+	case r := <-w.data:
+		return r, nil
+	case <-ctx.Done():
+	}
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+	if i := slices.Index(b.wait, w); i != -1 {
+		b.wait = slices.Delete(b.wait, i, i+1)
+		b.parked.Store(int32(len(b.wait)))
+		// The callers behind may fit now.
+		b.wake()
+		return nil, ctx.Err()
+	}
+	// The memory was handed over in the meantime, give it back.
+	b.close(<-w.data)
+	return nil, ctx.Err()
+}
+
+// AllocAligned allocates a byte slice of the requested size whose address is a multiple of align, which must be a power
+// of 2. Blocks are aligned to their size relative to the start of the pool, so the block is at least align bytes large
+// and a small size with a large alignment wastes memory. If the pool itself is less aligned than align, as a pool on
+// the Go heap usually is beyond 8 KiB, the block is cut out of a larger one. That only works for a block no larger than
+// the alignment of the pool, an error is returned otherwise. Like TryAlloc, it neither falls back to the heap nor
+// blocks, and ErrExhausted is returned if the memory is not available. In guard mode, alignments larger than 16 bytes
+// are not supported. This method is thread-safe.
+func (b *Allocator) AllocAligned(size int, align int) ([]byte, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("balloc: invalid size %d", size)
+	}
+	if !isp2(align) || align > b.Inner.MaxTotal {
+		return nil, fmt.Errorf("balloc: invalid alignment %d", align)
+	}
+	if b.Guard && align > guardSize {
+		return nil, fmt.Errorf("balloc: alignment %d is not supported in guard mode", align)
+	}
+	need := b.order(size)
+	order := max(need, log2(b.Inner.MinBlock, max(b.Inner.MinBlock, align)))
+	if order > b.Inner.MaxOrder {
+		return nil, fmt.Errorf("balloc: size %d is larger than the pool", size)
+	}
 	b.Mutex.Lock()
 	defer b.Mutex.Unlock()
-	order := log2(b.Inner.MinBlock, max(b.Inner.MinBlock, npo2(size)))
-	block := b.Inner.Alloc(order)
+	if len(b.wait) != 0 {
+		return nil, ErrExhausted
+	}
+	inner, block := b.alloc(order)
 	if block.Offset == -1 {
-		log.Println("balloc: out of memory")
-		return make([]byte, size)
+		return nil, ErrExhausted
+	}
+	// The block is aligned to align relative to the start of the pool. If the pool is less aligned, the first address in
+	// the block that is a multiple of align is skew bytes in, and it starts a smaller block if skew is a multiple of its
+	// size.
+	if skew := -int(uintptr(unsafe.Pointer(&inner.PreAlloc[0])) % uintptr(align)); skew != 0 {
+		skew += align
+		if skew%(inner.MinBlock<<need) == 0 {
+			inner.carve(block.Offset, order, block.Offset+skew, need)
+			block = Blockinfo{Offset: block.Offset + skew, Length: inner.MinBlock << need}
+		}
+	}
+	r := b.hand(inner, block, size, nil)
+	if uintptr(unsafe.Pointer(unsafe.SliceData(r)))%uintptr(align) != 0 {
+		b.close(r)
+		return nil, fmt.Errorf("balloc: memory pool is not aligned to %d bytes", align)
+	}
+	return r, nil
+}
+
+// AllocOrder allocates a whole block of the given order, MinBlock * 2^order bytes, and returns it with its full length
+// so that none of the block is wasted. In guard mode the guard zones are taken from the block. Like TryAlloc, it
+// neither falls back to the heap nor blocks, and ErrExhausted is returned if the memory is not available. This method
+// is thread-safe.
+func (b *Allocator) AllocOrder(order int) ([]byte, error) {
+	if order < 0 || order > b.Inner.MaxOrder {
+		return nil, fmt.Errorf("balloc: invalid order %d", order)
+	}
+	size := b.Inner.MinBlock << order
+	if b.Guard {
+		size -= guardSize * 2
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("balloc: order %d is too small for guard mode", order)
+	}
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+	if len(b.wait) != 0 {
+		return nil, ErrExhausted
+	}
+	r := b.take(order, size, nil)
+	if r == nil {
+		return nil, ErrExhausted
+	}
+	return r, nil
+}
+
+// A waiter is a caller blocked in AllocContext.
+type waiter struct {
+	size int
+	data chan []byte
+	// Call stack of the caller in tracking mode.
+	stack []uintptr
+}
+
+// Function wake serves blocked callers in FIFO order, as long as their allocations succeed.
+func (b *Allocator) wake() {
+	for len(b.wait) != 0 {
+		r := b.take(b.order(b.wait[0].size), b.wait[0].size, b.wait[0].stack)
+		if r == nil {
+			break
+		}
+		b.wait[0].data <- r
+		b.wait = b.wait[1:]
+		b.parked.Store(int32(len(b.wait)))
+	}
+}
+
+// Function grab allocates from the memory pool, or applies the non-blocking part of the exhaustion policy.
+func (b *Allocator) grab(size int) ([]byte, error) {
+	if r := b.get(size); r != nil {
+		return r, nil
+	}
+	if b.Policy == PolicyHeap {
+		b.heap++
+		// Like a block of the pool, an empty fallback has a capacity, so that it can be closed.
+		r := make([]byte, size, max(size, 1))
+		if b.Debug {
+			if b.fall == nil {
+				b.fall = map[uintptr]struct{}{}
+			}
+			b.fall[uintptr(unsafe.Pointer(unsafe.SliceData(r)))] = struct{}{}
+		}
+		return r, nil
+	}
+	return nil, ErrExhausted
+}
+
+// Function get allocates from the memory pool unless there are blocked callers, which must be served first. It returns
+// nil on failure.
+func (b *Allocator) get(size int) []byte {
+	if len(b.wait) != 0 {
+		return nil
+	}
+	return b.take(b.order(size), size, nil)
+}
+
+// Function order returns the order of the block needed for an allocation of size bytes.
+func (b *Allocator) order(size int) int {
+	need := size
+	if b.Guard {
+		need = size + guardSize*2
+	}
+	return log2(b.Inner.MinBlock, max(b.Inner.MinBlock, npo2(need)))
+}
+
+// Function take allocates size bytes in a block of the given order from the memory pool. It returns nil on failure. In
+// tracking mode the allocation is recorded with the given call stack, or with the current one if it is nil.
+func (b *Allocator) take(order int, size int, stack []uintptr) []byte {
+	inner, block := b.alloc(order)
+	if block.Offset == -1 {
+		return nil
+	}
+	return b.hand(inner, block, size, stack)
+}
+
+// Function hand turns a block newly allocated from inner into a slice of size bytes, accounting and recording it.
+func (b *Allocator) hand(inner *Algorithm, block Blockinfo, size int, stack []uintptr) []byte {
+	b.account(block.Length)
+	if b.Track {
+		b.record(inner, block, stack)
+	}
+	data := inner.PreAlloc[block.Offset : block.Offset+block.Length : block.Offset+block.Length]
+	if !b.Guard {
+		return data[:size]
+	}
+	istr(data, 0, size)
+	for i := 8; i < guardSize; i++ {
+		data[i] = guardByte
+	}
+	for i := guardSize + size; i < len(data); i++ {
+		data[i] = guardByte
+	}
+	if size == 0 {
+		// An empty slice without capacity has no address to find its block by. The spare byte is a canary, so an append
+		// to it is still caught by Close.
+		return data[guardSize : guardSize : guardSize+1]
+	}
+	return data[guardSize : guardSize+size : guardSize+size]
+}
+
+// Function alloc allocates a block of the specified order from the first arena that has room for it, creating a new
+// arena if necessary. It returns a Blockinfo with Offset=-1 if allocation fails.
+func (b *Allocator) alloc(order int) (*Algorithm, Blockinfo) {
+	b.sweep()
+	if block := b.Inner.Alloc(order); block.Offset != -1 {
+		return b.Inner, block
+	}
+	for _, e := range b.extra {
+		if block := e.inner.Alloc(order); block.Offset != -1 {
+			e.since = time.Time{}
+			return e.inner, block
+		}
+	}
+	if order > b.Inner.MaxOrder || len(b.extra)+1 >= b.MaxArena {
+		return nil, Blockinfo{Offset: -1, Length: 0}
+	}
+	e := &arena{inner: NewAlgorithm(b.Inner.MinBlock, b.Inner.MaxTotal)}
+	b.extra = append(b.extra, e)
+	return e.inner, e.inner.Alloc(order)
+}
+
+// Function timer arranges for the free arena e to be swept once IdleTime has passed.
+func (b *Allocator) timer(e *arena) {
+	if e.sweep != nil {
+		e.sweep.Reset(b.IdleTime)
+		return
+	}
+	e.sweep = time.AfterFunc(b.IdleTime, func() {
+		b.Mutex.Lock()
+		defer b.Mutex.Unlock()
+		b.sweep()
+	})
+}
+
+// Function sweep releases additional arenas that have been completely free for longer than IdleTime.
+func (b *Allocator) sweep() {
+	if len(b.extra) == 0 {
+		return
+	}
+	now := time.Now()
+	b.extra = slices.DeleteFunc(b.extra, func(e *arena) bool {
+		if e.since.IsZero() || now.Sub(e.since) < b.IdleTime {
+			return false
+		}
+		e.sweep.Stop()
+		return true
+	})
+}
+
+// Unmap releases the memory pool of an allocator created by NewMmap or NewFile. For a file backed pool the state of
+// the allocator is written back to the file with msync before the mapping is released, so it can be opened again with
+// NewFile. The allocator and all memory allocated from it must not be used afterwards. For an allocator created by
+// New it does nothing. This method is thread-safe.
+func (b *Allocator) Unmap() error {
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+	if b.unmap == nil {
+		return nil
+	}
+	err := b.unmap()
+	b.unmap = nil
+	return err
+}
+
+// Realloc changes the size of the allocation data to size, preserving its contents up to the smaller of the old and
+// new block sizes. It grows the block in place when the buddies above it are free, and shrinks it in place by freeing
+// its upper halves. Only when neither is possible, a new block is allocated and the contents are copied. An empty
+// slice behaves like Alloc, a heap-allocated slice is always copied, and any other foreign slice is reported like by
+// Close. Realloc never blocks: if the pool is exhausted it falls back to the heap under PolicyHeap, and returns
+// ErrExhausted otherwise. On error data is left untouched. This method is thread-safe.
+func (b *Allocator) Realloc(data []byte, size int) ([]byte, error) {
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+	if cap(data) == 0 {
+		return b.grab(size)
+	}
+	inner, block, err := b.block(data)
+	if errors.Is(err, ErrForeign) && b.fallback(data) {
+		r, err := b.grab(size)
+		if err != nil {
+			return nil, err
+		}
+		delete(b.fall, uintptr(unsafe.Pointer(unsafe.SliceData(data))))
+		copy(r, data[:cap(data)])
+		return r, nil
+	}
+	if err == nil && b.Guard {
+		err = check(inner, block)
 	}
-	return b.Inner.PreAlloc[block.Offset : block.Offset+size]
+	if err != nil {
+		return nil, b.fail(err, data)
+	}
+	order := log2(inner.MinBlock, block.Length)
+	grow := log2(inner.MinBlock, max(inner.MinBlock, npo2(size)))
+	if !b.Guard && inner.Resize(block.Offset, order, grow) {
+		b.account(inner.MinBlock<<grow - block.Length)
+		b.resize(inner, block, inner.MinBlock<<grow)
+		if grow < order {
+			b.wake()
+		}
+		return inner.PreAlloc[block.Offset : block.Offset+size : block.Offset+inner.MinBlock<<grow], nil
+	}
+	r, err := b.grab(size)
+	if err != nil {
+		return nil, err
+	}
+	copy(r, data[:cap(data)])
+	// The old block has already been checked, closing it can not fail.
+	b.close(data)
+	return r, nil
 }
 
-// Avail returns the total available memory in the pool. This method is thread-safe.
+// Function account records that n bytes have been allocated, or freed if n is negative.
+func (b *Allocator) account(n int) {
+	b.used += n
+	b.peak = max(b.peak, b.used)
+}
+
+// Avail returns the total available memory in all arenas. This method is thread-safe.
 func (b *Allocator) Avail() int {
 	b.Mutex.Lock()
 	defer b.Mutex.Unlock()
-	return b.Inner.Avail()
+	return b.avail()
+}
+
+func (b *Allocator) avail() int {
+	s := b.Inner.Avail()
+	for _, e := range b.extra {
+		s += e.inner.Avail()
+	}
+	return s
 }
 
 // Close returns a previously allocated byte slice back to the memory pool. The slice must have been allocated by this
-// Allocator's Alloc method. If the slice is not from this pool (e.g., heap-allocated fallback), it's safely ignored.
-// This method is thread-safe.
-func (b *Allocator) Close(data []byte) {
+// Allocator's Alloc method. A heap-allocated fallback is safely ignored, any other slice that is not from this pool is
+// reported with ErrForeign. Without debug mode, heap fallbacks are not recorded: once one has been made, foreign slices
+// can no longer be told apart from them and are ignored too.
+// The order of the block is taken from the allocator's own metadata, so the slice may have been resliced to any length
+// as long as it still starts at the beginning of its block. Double frees, interior pointers and empty slices are
+// reported as errors, or cause a panic in debug mode. This method is thread-safe.
+func (b *Allocator) Close(data []byte) error {
 	b.Mutex.Lock()
 	defer b.Mutex.Unlock()
-	blockOffset := int(uintptr(unsafe.Pointer(&data[0])) - uintptr(unsafe.Pointer(&b.Inner.PreAlloc[0])))
-	if blockOffset < 0 || blockOffset >= b.Inner.MaxTotal {
+	return b.fail(b.close(data), data)
+}
+
+// Function fail returns err, or panics with diagnostics about data if err is not nil and debug mode is on.
+func (b *Allocator) fail(err error, data []byte) error {
+	if err != nil && b.Debug {
+		log.Panicln(err, "cap", cap(data), "len", len(data), "avail", b.avail())
+	}
+	return err
+}
+
+func (b *Allocator) close(data []byte) error {
+	inner, block, err := b.block(data)
+	if errors.Is(err, ErrForeign) && b.fallback(data) {
+		// Heap-allocated fallback, let the garbage collector take care of it.
+		delete(b.fall, uintptr(unsafe.Pointer(unsafe.SliceData(data))))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if b.Guard {
+		if err := check(inner, block); err != nil {
+			return err
+		}
+	}
+	if err := inner.Close(block); err != nil {
+		return err
+	}
+	b.account(-block.Length)
+	b.forget(inner, block)
+	defer b.wake()
+	if inner != b.Inner && inner.FreeList[inner.MaxOrder] != -1 {
+		for _, e := range b.extra {
+			if e.inner == inner {
+				e.since = time.Now()
+				b.timer(e)
+			}
+		}
+	}
+	b.sweep()
+	return nil
+}
+
+// Function fallback reports whether the foreign slice data may be a heap fallback made by the allocator. Only in debug
+// mode the fallbacks are known, the caller forgets data once it is done with it.
+func (b *Allocator) fallback(data []byte) bool {
+	if !b.Debug {
+		return b.heap != 0
+	}
+	_, ok := b.fall[uintptr(unsafe.Pointer(unsafe.SliceData(data)))]
+	return ok
+}
+
+// Function block finds the arena and the live block that data was allocated from.
+func (b *Allocator) block(data []byte) (*Algorithm, Blockinfo, error) {
+	if cap(data) == 0 {
+		return nil, Blockinfo{}, ErrEmpty
+	}
+	inner := b.owner(unsafe.SliceData(data))
+	if inner == nil {
+		return nil, Blockinfo{}, fmt.Errorf("%w: address %p", ErrForeign, unsafe.SliceData(data))
+	}
+	blockOffset := int(uintptr(unsafe.Pointer(unsafe.SliceData(data))) - uintptr(unsafe.Pointer(&inner.PreAlloc[0])))
+	if b.Guard {
+		if blockOffset < guardSize {
+			return nil, Blockinfo{}, fmt.Errorf("%w: offset %d inside guard zone", ErrInterior, blockOffset)
+		}
+		blockOffset -= guardSize
+	}
+	order, err := inner.Lookup(blockOffset)
+	if err != nil {
+		return nil, Blockinfo{}, err
+	}
+	return inner, Blockinfo{Offset: blockOffset, Length: inner.MinBlock << order}, nil
+}
+
+// Function owner returns the arena whose memory contains p, or nil.
+func (b *Allocator) owner(p *byte) *Algorithm {
+	if contains(b.Inner, p) {
+		return b.Inner
+	}
+	for _, e := range b.extra {
+		if contains(e.inner, p) {
+			return e.inner
+		}
+	}
+	return nil
+}
+
+// Function contains checks if p points into the memory pool of the arena.
+func contains(inner *Algorithm, p *byte) bool {
+	l := uintptr(unsafe.Pointer(&inner.PreAlloc[0]))
+	return uintptr(unsafe.Pointer(p)) >= l && uintptr(unsafe.Pointer(p)) < l+uintptr(inner.MaxTotal)
+}
+
+// Function check verifies the canary bytes around a block allocated in guard mode.
+func check(inner *Algorithm, block Blockinfo) error {
+	data := inner.PreAlloc[block.Offset : block.Offset+block.Length]
+	size := ildr(data, 0)
+	if size < 0 || size > block.Length-guardSize*2 {
+		return fmt.Errorf("%w: offset %d: corrupted size %d", ErrOverflow, block.Offset, size)
+	}
+	for i := 8; i < guardSize; i++ {
+		if data[i] != guardByte {
+			return fmt.Errorf("%w: offset %d: underflow at %d", ErrOverflow, block.Offset, i-guardSize)
+		}
+	}
+	for i := guardSize + size; i < len(data); i++ {
+		if data[i] != guardByte {
+			return fmt.Errorf("%w: offset %d size %d: overflow at %d", ErrOverflow, block.Offset, size, i-guardSize)
+		}
+	}
+	return nil
+}
+
+// Stats describes the state of an Allocator.
+type Stats struct {
+	// Arena is the number of arenas, including the primary one.
+	Arena int
+	// Avail is the total number of free bytes in all arenas.
+	Avail int
+	// Fragment is the fragmentation ratio, 1 - Largest/Avail. It is 0 when the free memory is a single block, and gets
+	// closer to 1 as the free memory is scattered over many small blocks.
+	Fragment float64
+	// Free is the number of free blocks of each order in all arenas.
+	Free []int
+	// Heap is the number of allocations that fell back to the heap because all arenas were exhausted.
+	Heap int
+	// Largest is the size of the largest block that can be allocated from the existing arenas.
+	Largest int
+	// Live is the number of live allocations. Blocks cached by a Sharded front end count as live.
+	Live int
+	// Peak is the high-water mark of Used.
+	Peak int
+	// Used is the total number of allocated bytes, including the rounding to block sizes.
+	Used int
+}
+
+// Stats returns statistics about free and used memory. It scans the metadata of all arenas, so it is not meant to be
+// called on a hot path. This method is thread-safe.
+func (b *Allocator) Stats() Stats {
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+	s := Stats{
+		Arena: 1 + len(b.extra),
+		Free:  make([]int, b.Inner.MaxOrder+1),
+		Heap:  b.heap,
+		Peak:  b.peak,
+		Used:  b.used,
+	}
+	for _, inner := range b.arenas() {
+		for i := range inner.FreeMap {
+			if inner.FreeMap[i] != 0 {
+				order := int(inner.FreeMap[i]) - 1
+				s.Free[order]++
+				s.Avail += inner.MinBlock << order
+				s.Largest = max(s.Largest, inner.MinBlock<<order)
+			}
+			if inner.OrderMap[i] != 0 {
+				s.Live++
+			}
+		}
+	}
+	if s.Avail != 0 {
+		s.Fragment = 1 - float64(s.Largest)/float64(s.Avail)
+	}
+	return s
+}
+
+// Dump renders a map of every arena to w. Each character stands for an equal share of the arena: '.' is free memory,
+// '#' is used memory, and '+' is a mix of both. This method is thread-safe.
+func (b *Allocator) Dump(w io.Writer) error {
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+	for i, inner := range b.arenas() {
+		units := inner.MaxTotal / inner.MinBlock
+		// Mark the free units of the arena.
+		free := make([]bool, units)
+		for j, e := range inner.FreeMap {
+			for k := range (1 << e) >> 1 {
+				free[j+k] = true
+			}
+		}
+		cells := min(units, 1024)
+		share := units / cells
+		if _, err := fmt.Fprintf(w, "arena %d: %d bytes, %d bytes per cell\n", i, inner.MaxTotal,
+			share*inner.MinBlock); err != nil {
+			return err
+		}
+		line := []byte{}
+		for j := range cells {
+			n := 0
+			for _, f := range free[j*share : (j+1)*share] {
+				if f {
+					n++
+				}
+			}
+			switch n {
+			case 0:
+				line = append(line, '#')
+			case share:
+				line = append(line, '.')
+			default:
+				line = append(line, '+')
+			}
+			if len(line) == 64 || j == cells-1 {
+				if _, err := fmt.Fprintf(w, "%08x %s\n", (j+1-len(line))*share*inner.MinBlock, line); err != nil {
+					return err
+				}
+				line = line[:0]
+			}
+		}
+	}
+	return nil
+}
+
+// Function arenas returns all arenas, starting with the primary one.
+func (b *Allocator) arenas() []*Algorithm {
+	r := []*Algorithm{b.Inner}
+	for _, e := range b.extra {
+		r = append(r, e.inner)
+	}
+	return r
+}
+
+// Size returns the usable size of the block that data was allocated from, which is at least the size passed to Alloc.
+// Like Close, it does not depend on the current length of data. In guard mode this is exactly the size passed to
+// Alloc. This method is thread-safe.
+func (b *Allocator) Size(data []byte) (int, error) {
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+	inner, block, err := b.block(data)
+	if err != nil {
+		return 0, err
+	}
+	if b.Guard {
+		return ildr(inner.PreAlloc, block.Offset), nil
+	}
+	return block.Length, nil
+}
+
+// Size of an int in bytes. A free block holds two of them, which limits the minimum block size.
+const isize = int(unsafe.Sizeof(int(0)))
+
+// Function word returns a link of the free block at blockOffset, the next block for i = 0 and the previous one for
+// i = 1. Links are ints, or int32 if blocks are too small to hold two ints.
+func (b *Algorithm) word(blockOffset int, i int) int {
+	if b.MinBlock >= isize*2 {
+		return ildr(b.PreAlloc, blockOffset+i*isize)
+	}
+	return int(*(*int32)(unsafe.Pointer(&b.PreAlloc[blockOffset+i*4])))
+}
+
+// Function store sets a link of the free block at blockOffset, see word.
+func (b *Algorithm) store(blockOffset int, i int, v int) {
+	if b.MinBlock >= isize*2 {
+		istr(b.PreAlloc, blockOffset+i*isize, v)
 		return
 	}
-	b.Inner.Close(Blockinfo{
-		Offset: blockOffset,
-		Length: max(b.Inner.MinBlock, npo2(len(data))),
-	})
+	*(*int32)(unsafe.Pointer(&b.PreAlloc[blockOffset+i*4])) = int32(v)
 }
 
 // Function ildr reads an int value from byte slice m at offset o.
@@ -188,30 +1078,72 @@ func npo2(n int) int {
 	return 1 << (bits.Len(uint(n - 1)))
 }
 
-// New creates a new buddy allocator with the specified parameters. Parameter minBlock is the minimum allocation unit
-// size (must be a power of 2). Parameter maxTotal is the total memory pool size (must be a power of 2).
-func New(minBlock int, maxTotal int) *Allocator {
+// Function params validates the parameters of a memory pool.
+func params(minBlock int, maxTotal int) error {
 	if !isp2(minBlock) {
-		log.Panicln("balloc: max block is not a power of 2")
+		return errors.New("balloc: min block is not a power of 2")
+	}
+	if minBlock < 8 {
+		return errors.New("balloc: min block is too small")
 	}
 	if !isp2(maxTotal) {
-		log.Panicln("balloc: max total is not a power of 2")
+		return errors.New("balloc: max total is not a power of 2")
+	}
+	if maxTotal < minBlock {
+		return errors.New("balloc: max total is smaller than min block")
+	}
+	if minBlock < isize*2 && maxTotal > math.MaxInt32 {
+		return errors.New("balloc: max total is too large for the min block")
+	}
+	return nil
+}
+
+// Function reset marks the whole memory pool as a single free block.
+func (b *Algorithm) reset() {
+	for i := range b.FreeList {
+		b.FreeList[i] = -1
+	}
+	clear(b.FreeMap)
+	clear(b.OrderMap)
+	b.link(0, b.MaxOrder)
+}
+
+// NewAlgorithm creates a buddy algorithm that manages a memory pool of maxTotal bytes, split into blocks of at least
+// minBlock bytes. Both must be powers of 2, and minBlock must be at least 8. Free blocks are linked with ints, or with
+// int32 if minBlock can not hold two ints, which limits maxTotal to 1 GiB.
+func NewAlgorithm(minBlock int, maxTotal int) *Algorithm {
+	if err := params(minBlock, maxTotal); err != nil {
+		log.Panicln(err)
 	}
 	order := log2(minBlock, maxTotal)
 	inner := &Algorithm{
 		FreeList: make([]int, order+1),
+		FreeMap:  make([]uint8, maxTotal/minBlock),
 		MaxOrder: order,
 		MaxTotal: maxTotal,
 		MinBlock: minBlock,
+		OrderMap: make([]uint8, maxTotal/minBlock),
 		PreAlloc: make([]byte, maxTotal),
 	}
-	for i := range inner.FreeList {
-		inner.FreeList[i] = -1
-	}
-	inner.FreeList[order] = 0
-	istr(inner.PreAlloc, 0, -1)
+	inner.reset()
+	return inner
+}
+
+// New creates a new buddy allocator with the specified parameters. Parameter minBlock is the minimum allocation unit
+// size (must be a power of 2). Parameter maxTotal is the total memory pool size (must be a power of 2). The allocator
+// has a single arena, set MaxArena to let it grow.
+func New(minBlock int, maxTotal int) *Allocator {
+	return newAllocator(NewAlgorithm(minBlock, maxTotal))
+}
+
+// Function newAllocator creates an allocator with inner as its primary arena.
+func newAllocator(inner *Algorithm) *Allocator {
 	return &Allocator{
-		Inner: inner,
-		Mutex: &sync.Mutex{},
+		IdleTime: time.Minute,
+		Inner:    inner,
+		MaxArena: 1,
+		Mutex:    &sync.Mutex{},
+		used:     inner.MaxTotal - inner.Avail(),
+		peak:     inner.MaxTotal - inner.Avail(),
 	}
 }
@@ -1,10 +1,53 @@
 package balloc
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"math/rand/v2"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+	"unsafe"
 )
 
+func BenchmarkAllocator(b *testing.B) {
+	balloc := New(64, 64*1024*1024)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			balloc.Close(balloc.Alloc(256))
+		}
+	})
+}
+
+func BenchmarkFragmented(b *testing.B) {
+	// Every other block is free, so the free list of order 0 is as long as it gets, and no block can be merged.
+	balloc := New(64, 16*1024*1024)
+	record := [][]byte{}
+	for range 16 * 1024 * 1024 / 64 {
+		record = append(record, balloc.Alloc(64))
+	}
+	for i := 0; i < len(record); i += 2 {
+		balloc.Close(record[i])
+	}
+	for b.Loop() {
+		balloc.Close(balloc.Alloc(64))
+	}
+}
+
+func BenchmarkSharded(b *testing.B) {
+	balloc := NewSharded(New(64, 64*1024*1024), 0)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			balloc.Close(balloc.Alloc(256))
+		}
+	})
+}
+
 func TestFuzz(t *testing.T) {
 	maxAlive := 1024
 	maxAlloc := 2048
@@ -21,15 +64,23 @@ func TestFuzz(t *testing.T) {
 		}
 		switch action {
 		case 0:
-			record = append(record, balloc.Alloc(max(1, rand.Int()%maxAlloc)))
+			data := balloc.Alloc(max(1, rand.Int()%maxAlloc))
+			record = append(record, data[:rand.Int()%(len(data)+1)])
 		case 1:
 			i := rand.Int() % len(record)
-			balloc.Close(record[i])
+			if err := balloc.Close(record[i]); err != nil {
+				t.Fatal(err)
+			}
 			record = append(record[:i], record[i+1:]...)
 		}
 	}
+	if err := balloc.Inner.Check(); err != nil {
+		t.Fatal(err)
+	}
 	for _, e := range record {
-		balloc.Close(e)
+		if err := balloc.Close(e); err != nil {
+			t.Fatal(err)
+		}
 	}
 	for i := range balloc.Inner.MaxOrder {
 		if balloc.Inner.FreeList[i] != -1 {
@@ -43,3 +94,788 @@ func TestFuzz(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestClose(t *testing.T) {
+	balloc := New(64, 1024)
+	a := balloc.Alloc(128)
+	if err := balloc.Close(a[64:]); !errors.Is(err, ErrInterior) {
+		t.FailNow()
+	}
+	if err := balloc.Close(nil); !errors.Is(err, ErrEmpty) {
+		t.FailNow()
+	}
+	if err := balloc.Close(make([]byte, 64)); !errors.Is(err, ErrForeign) {
+		t.FailNow()
+	}
+	if err := balloc.Close(a); err != nil {
+		t.FailNow()
+	}
+	if err := balloc.Close(a); !errors.Is(err, ErrDoubleFree) {
+		t.FailNow()
+	}
+	if balloc.Avail() != 1024 {
+		t.FailNow()
+	}
+	balloc.Debug = true
+	defer func() {
+		if recover() == nil {
+			t.FailNow()
+		}
+	}()
+	balloc.Close(a)
+}
+
+func TestSize(t *testing.T) {
+	balloc := New(64, 1024)
+	a := balloc.Alloc(100)
+	if n, err := balloc.Size(a[:1]); err != nil || n != 128 {
+		t.FailNow()
+	}
+	if _, err := balloc.Size(make([]byte, 64)); !errors.Is(err, ErrForeign) {
+		t.FailNow()
+	}
+	if err := balloc.Close(a[:0]); err != nil {
+		t.FailNow()
+	}
+	if balloc.Avail() != 1024 {
+		t.FailNow()
+	}
+}
+
+func TestGuard(t *testing.T) {
+	balloc := New(64, 1024)
+	a := balloc.Alloc(100)
+	if len(a) != 100 || cap(a) != 128 {
+		t.FailNow()
+	}
+	b := balloc.Alloc(100)
+	b[0] = 0xff
+	if d := append(a, make([]byte, 64)...); &d[0] == &a[0] || b[0] != 0xff {
+		t.FailNow()
+	}
+	balloc.Close(a)
+	balloc.Close(b)
+	balloc.Guard = true
+	c := balloc.Alloc(100)
+	if len(c) != 100 || cap(c) != 100 {
+		t.FailNow()
+	}
+	if n, err := balloc.Size(c); err != nil || n != 100 {
+		t.FailNow()
+	}
+	unsafe.Slice(&c[0], 101)[100] = 0
+	if err := balloc.Close(c); !errors.Is(err, ErrOverflow) {
+		t.FailNow()
+	}
+	unsafe.Slice(&c[0], 101)[100] = guardByte
+	if err := balloc.Close(c); err != nil {
+		t.FailNow()
+	}
+	d := balloc.Alloc(0)
+	if len(d) != 0 || cap(d) == 0 {
+		t.FailNow()
+	}
+	if err := balloc.Close(d); err != nil {
+		t.FailNow()
+	}
+	if balloc.Avail() != 1024 {
+		t.FailNow()
+	}
+}
+
+func TestArena(t *testing.T) {
+	balloc := New(64, 1024)
+	balloc.MaxArena = 2
+	balloc.IdleTime = 0
+	a := balloc.Alloc(1024)
+	b := balloc.Alloc(1024)
+	if _, err := balloc.Size(b); err != nil {
+		t.FailNow()
+	}
+	c := balloc.Alloc(1024)
+	if _, err := balloc.Size(c); !errors.Is(err, ErrForeign) {
+		t.FailNow()
+	}
+	if balloc.Avail() != 0 {
+		t.FailNow()
+	}
+	if err := balloc.Close(b); err != nil {
+		t.FailNow()
+	}
+	if len(balloc.extra) != 0 || balloc.Avail() != 0 {
+		t.FailNow()
+	}
+	if err := balloc.Close(a); err != nil {
+		t.FailNow()
+	}
+	if balloc.Avail() != 1024 {
+		t.FailNow()
+	}
+	// An idle arena is released by a timer, without further calls to the allocator.
+	balloc.IdleTime = time.Millisecond * 10
+	a = balloc.Alloc(1024)
+	b = balloc.Alloc(1024)
+	balloc.Close(b)
+	for {
+		balloc.Mutex.Lock()
+		n := len(balloc.extra)
+		balloc.Mutex.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	balloc.Close(a)
+}
+
+func TestSharded(t *testing.T) {
+	balloc := NewSharded(New(64, 1024*1024), 4)
+	done := make(chan struct{})
+	for range 8 {
+		go func() {
+			record := [][]byte{}
+			for i := range 4096 {
+				record = append(record, balloc.Alloc(1+i%1024))
+				if len(record) > 16 {
+					if err := balloc.Close(record[0]); err != nil {
+						t.Error(err)
+					}
+					record = record[1:]
+				}
+			}
+			for _, e := range record {
+				if err := balloc.Close(e); err != nil {
+					t.Error(err)
+				}
+			}
+			done <- struct{}{}
+		}()
+	}
+	for range 8 {
+		<-done
+	}
+	a := balloc.Alloc(64)
+	balloc.Close(a)
+	if err := balloc.Close(a); !errors.Is(err, ErrDoubleFree) {
+		t.FailNow()
+	}
+	if balloc.Avail() != 1024*1024 {
+		t.FailNow()
+	}
+	balloc.Flush()
+	if balloc.Inner.Avail() != 1024*1024 {
+		t.FailNow()
+	}
+	// A block of the central allocator goes back to it, not to a cache.
+	b := balloc.Inner.Alloc(64)
+	if err := balloc.Close(b); err != nil || balloc.Inner.Avail() != 1024*1024 {
+		t.FailNow()
+	}
+	// With tracking set before, the shards pass every allocation through to the central allocator.
+	tb := &leakTB{}
+	inner := New(64, 1024*1024)
+	LeakCheck(tb, inner)
+	balloc = NewSharded(inner, 4)
+	for range 4 {
+		go func() {
+			for range 64 {
+				balloc.Close(balloc.Alloc(64))
+			}
+			done <- struct{}{}
+		}()
+	}
+	for range 4 {
+		<-done
+	}
+	balloc.Flush()
+	tb.clean[0]()
+	if len(tb.fails) != 0 || inner.Avail() != 1024*1024 {
+		t.Fatal(tb.fails)
+	}
+}
+
+func TestShardedPolicy(t *testing.T) {
+	balloc := NewSharded(New(64, 1024), 1)
+	balloc.Inner.Policy = PolicyBlock
+	balloc.Batch = 4
+	parked := func() {
+		for balloc.Inner.parked.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		// Let the waiter reclaim the caches.
+		time.Sleep(time.Millisecond * 10)
+	}
+	done := make(chan []byte)
+	// A small allocation blocks without holding its shard.
+	a := balloc.Alloc(1024)
+	go func() { done <- balloc.Alloc(64) }()
+	parked()
+	balloc.Flush()
+	balloc.Close(a)
+	b := <-done
+	if len(b) != 64 {
+		t.FailNow()
+	}
+	// Cached blocks are handed to the waiter, and so are blocks closed while it waits.
+	x := balloc.Alloc(64)
+	go func() { done <- balloc.Alloc(1024) }()
+	parked()
+	balloc.Close(x)
+	balloc.Close(b)
+	c := <-done
+	if len(c) != 1024 {
+		t.FailNow()
+	}
+	balloc.Close(c)
+	if balloc.Inner.Avail() != 1024 {
+		t.FailNow()
+	}
+}
+
+func TestStats(t *testing.T) {
+	balloc := New(64, 1024)
+	a := balloc.Alloc(64)
+	b := balloc.Alloc(256)
+	c := balloc.Alloc(2048)
+	s := balloc.Stats()
+	if s.Live != 2 || s.Used != 320 || s.Peak != 320 || s.Heap != 1 || s.Avail != 704 || s.Largest != 512 {
+		t.FailNow()
+	}
+	if !slices.Equal(s.Free, []int{1, 1, 0, 1, 0}) || s.Fragment != 1-512.0/704.0 {
+		t.FailNow()
+	}
+	buf := bytes.Buffer{}
+	balloc.Dump(&buf)
+	if buf.String() != "arena 0: 1024 bytes, 64 bytes per cell\n00000000 #...####........\n" {
+		t.FailNow()
+	}
+	balloc.Close(a)
+	balloc.Close(b)
+	balloc.Close(c)
+	s = balloc.Stats()
+	if s.Live != 0 || s.Used != 0 || s.Peak != 320 || s.Fragment != 0 {
+		t.FailNow()
+	}
+}
+
+func TestSlab(t *testing.T) {
+	type Record struct {
+		A int64
+		B [3]float32
+	}
+	balloc := New(64, 1024*1024)
+	slab := NewSlab[Record](balloc, 100)
+	record := []*Record{}
+	for i := range 1000 {
+		r, err := slab.Get()
+		if err != nil || r.A != 0 {
+			t.FailNow()
+		}
+		r.A = int64(i)
+		record = append(record, r)
+	}
+	for i, r := range record {
+		if r.A != int64(i) {
+			t.FailNow()
+		}
+	}
+	if err := slab.Put(record[0]); err != nil {
+		t.FailNow()
+	}
+	if err := slab.Put(record[0]); !errors.Is(err, ErrDoubleFree) {
+		t.FailNow()
+	}
+	if err := slab.Put((*Record)(unsafe.Add(unsafe.Pointer(record[1]), 4))); !errors.Is(err, ErrInterior) {
+		t.FailNow()
+	}
+	if err := slab.Put(&Record{}); !errors.Is(err, ErrForeign) {
+		t.FailNow()
+	}
+	for _, r := range record[1:] {
+		if err := slab.Put(r); err != nil {
+			t.FailNow()
+		}
+	}
+	if balloc.Avail() != 1024*1024 {
+		t.FailNow()
+	}
+	// Exhaustion is an error under any policy.
+	balloc.Policy = PolicyError
+	a := balloc.Alloc(1024 * 1024)
+	if r, err := slab.Get(); r != nil || !errors.Is(err, ErrExhausted) {
+		t.FailNow()
+	}
+	balloc.Close(a)
+	defer func() {
+		if recover() == nil {
+			t.FailNow()
+		}
+	}()
+	NewSlab[*Record](balloc, 100)
+}
+
+func TestMmap(t *testing.T) {
+	balloc, err := NewMmap(64, 1024*1024, true)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := balloc.Alloc(1000)
+	a[999] = 1
+	if err := balloc.Close(a); err != nil {
+		t.FailNow()
+	}
+	if balloc.Avail() != 1024*1024 {
+		t.FailNow()
+	}
+	if err := balloc.Unmap(); err != nil {
+		t.FailNow()
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "balloc")
+	balloc, err := NewFile(path, 64, 1024*1024)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := balloc.Alloc(100)
+	copy(a, "Hello World!")
+	b := balloc.Alloc(5000)
+	balloc.Close(b)
+	if err := balloc.Unmap(); err != nil {
+		t.FailNow()
+	}
+	if _, err := NewFile(path, 64, 2048*1024); err == nil {
+		t.FailNow()
+	}
+	balloc, err = NewFile(path, 64, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer balloc.Unmap()
+	a = balloc.Inner.PreAlloc[:100]
+	if string(a[:12]) != "Hello World!" || balloc.Stats().Used != 128 {
+		t.FailNow()
+	}
+	if err := balloc.Close(a); err != nil {
+		t.FailNow()
+	}
+	if balloc.Avail() != 1024*1024 {
+		t.FailNow()
+	}
+}
+
+func TestRealloc(t *testing.T) {
+	balloc := New(64, 1024)
+	a := balloc.Alloc(64)
+	copy(a, "Hello World!")
+	b, err := balloc.Realloc(a, 200)
+	if err != nil || &b[0] != &a[0] || len(b) != 200 || cap(b) != 256 || balloc.Avail() != 768 {
+		t.FailNow()
+	}
+	c := balloc.Alloc(256)
+	d, err := balloc.Realloc(b, 300)
+	if err != nil || &d[0] == &b[0] || string(d[:12]) != "Hello World!" || balloc.Avail() != 256 {
+		t.FailNow()
+	}
+	e, err := balloc.Realloc(d, 10)
+	if err != nil || &e[0] != &d[0] || cap(e) != 64 || balloc.Avail() != 704 {
+		t.FailNow()
+	}
+	if _, err := balloc.Realloc(b, 10); !errors.Is(err, ErrDoubleFree) {
+		t.FailNow()
+	}
+	if _, err := balloc.Realloc(make([]byte, 64), 10); !errors.Is(err, ErrForeign) {
+		t.FailNow()
+	}
+	balloc.Close(c)
+	balloc.Close(e)
+	if balloc.Avail() != 1024 {
+		t.FailNow()
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	balloc := New(64, 1024)
+	balloc.Policy = PolicyError
+	a := balloc.Alloc(1024)
+	if balloc.Alloc(64) != nil {
+		t.FailNow()
+	}
+	if _, err := balloc.TryAlloc(64); !errors.Is(err, ErrExhausted) {
+		t.FailNow()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := balloc.AllocContext(ctx, 64); !errors.Is(err, context.DeadlineExceeded) {
+		t.FailNow()
+	}
+	if _, err := balloc.AllocContext(context.Background(), 2048); !errors.Is(err, ErrExhausted) {
+		t.FailNow()
+	}
+	waiting := func(n int) {
+		for {
+			balloc.Mutex.Lock()
+			l := len(balloc.wait)
+			balloc.Mutex.Unlock()
+			if l == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	c1 := make(chan []byte)
+	c2 := make(chan []byte)
+	go func() {
+		r, _ := balloc.AllocContext(context.Background(), 1024)
+		c1 <- r
+	}()
+	waiting(1)
+	go func() {
+		r, _ := balloc.AllocContext(context.Background(), 64)
+		c2 <- r
+	}()
+	waiting(2)
+	// A small allocation must not overtake the blocked callers.
+	if _, err := balloc.TryAlloc(64); !errors.Is(err, ErrExhausted) {
+		t.FailNow()
+	}
+	balloc.Close(a)
+	b := <-c1
+	waiting(1)
+	balloc.Close(b)
+	c := <-c2
+	balloc.Close(c)
+	if balloc.Avail() != 1024 {
+		t.FailNow()
+	}
+}
+
+func TestForeign(t *testing.T) {
+	balloc := New(64, 1024)
+	balloc.Debug = true
+	a := balloc.Alloc(1024)
+	b := balloc.Alloc(64)
+	if err := balloc.Close(b); err != nil {
+		t.FailNow()
+	}
+	// An empty fallback is closed like any other.
+	if err := balloc.Close(balloc.Alloc(0)); err != nil {
+		t.FailNow()
+	}
+	c, err := balloc.Realloc(balloc.Alloc(64), 128)
+	if err != nil || balloc.Close(c) != nil {
+		t.FailNow()
+	}
+	if err := balloc.Close(a); err != nil {
+		t.FailNow()
+	}
+	defer func() {
+		if recover() == nil {
+			t.FailNow()
+		}
+	}()
+	balloc.Close(b)
+}
+
+type leakTB struct {
+	clean []func()
+	fails []string
+}
+
+func (t *leakTB) Cleanup(f func()) { t.clean = append(t.clean, f) }
+func (t *leakTB) Errorf(format string, args ...any) {
+	t.fails = append(t.fails, fmt.Sprintf(format, args...))
+}
+func (t *leakTB) Helper() {}
+
+func TestLeaks(t *testing.T) {
+	balloc := New(64, 1024)
+	tb := &leakTB{}
+	LeakCheck(tb, balloc)
+	a := balloc.Alloc(100)
+	b := balloc.Alloc(100)
+	c := balloc.Alloc(64)
+	balloc.Close(b)
+	c, _ = balloc.Realloc(c, 256)
+	buf := bytes.Buffer{}
+	balloc.Leaks(&buf)
+	s := buf.String()
+	if !strings.HasPrefix(s, "balloc: 2 live blocks, 384 bytes\n256 bytes in 1 blocks allocated at:\n") {
+		t.Fatal(s)
+	}
+	if !strings.Contains(s, "balloc.TestLeaks") || strings.Contains(s, "balloc.(*Allocator)") {
+		t.Fatal(s)
+	}
+	balloc.Close(c)
+	balloc.Close(a)
+	tb.clean[0]()
+	if len(tb.fails) != 0 {
+		t.FailNow()
+	}
+	balloc.Alloc(64)
+	tb.clean[0]()
+	if len(tb.fails) != 1 || !strings.Contains(tb.fails[0], "64 bytes in 1 blocks") {
+		t.FailNow()
+	}
+}
+
+func TestCheck(t *testing.T) {
+	corrupt := []func(b *Algorithm){
+		func(b *Algorithm) { b.store(b.FreeList[0], 1, 0) },
+		func(b *Algorithm) { b.FreeMap[b.FreeList[1]/b.MinBlock] = 0 },
+		func(b *Algorithm) { b.FreeMap[b.MaxTotal/b.MinBlock-1] = 1 },
+		func(b *Algorithm) { b.OrderMap[0] = 0 },
+		func(b *Algorithm) { b.OrderMap[1] = 1 },
+		func(b *Algorithm) { b.FreeList[2] = 64 },
+		func(b *Algorithm) { b.store(b.FreeList[0], 0, b.FreeList[0]) },
+		func(b *Algorithm) {
+			// Two free buddies that have not been merged.
+			b.OrderMap[0] = 0
+			b.link(0, 0)
+		},
+	}
+	for _, f := range corrupt {
+		b := NewAlgorithm(64, 1024)
+		b.Alloc(0)
+		if err := b.Check(); err != nil {
+			t.Fatal(err)
+		}
+		f(b)
+		if err := b.Check(); !errors.Is(err, ErrCorrupt) {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUnlink(t *testing.T) {
+	for _, minBlock := range []int{8, 64} {
+		b := NewAlgorithm(minBlock, minBlock*8)
+		list := func() []int {
+			r := []int{}
+			for n := b.FreeList[0]; n != -1; n = b.word(n, 0) {
+				r = append(r, n/minBlock)
+			}
+			return r
+		}
+		for range 8 {
+			b.Alloc(0)
+		}
+		// Free every other block, so that no buddies merge.
+		for _, i := range []int{0, 2, 4, 6} {
+			b.Close(Blockinfo{Offset: i * minBlock, Length: minBlock})
+		}
+		if !slices.Equal(list(), []int{6, 4, 2, 0}) {
+			t.FailNow()
+		}
+		// Freeing a buddy unlinks the free block from the middle, the head, and the tail of the list.
+		for _, c := range []struct {
+			unit int
+			want []int
+		}{{3, []int{6, 4, 0}}, {7, []int{4, 0}}, {1, []int{4}}} {
+			if err := b.Close(Blockinfo{Offset: c.unit * minBlock, Length: minBlock}); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(list(), c.want) {
+				t.Fatal(list())
+			}
+			if err := b.Check(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := params(4, 64); err == nil {
+		t.FailNow()
+	}
+}
+
+func FuzzAlgorithm(f *testing.F) {
+	f.Add([]byte{0, 0, 1, 2, 4, 1, 3, 3, 0, 5, 7})
+	f.Add([]byte{8, 0, 2, 4, 6, 8, 1, 1, 1, 1, 1, 10})
+	f.Add([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 5, 9, 13, 17, 21, 25, 29})
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		b := NewAlgorithm(64, 1024)
+		// The reference model marks each unit of MinBlock bytes that is live.
+		live := make([]bool, b.MaxTotal/b.MinBlock)
+		list := []Blockinfo{}
+		for _, op := range ops {
+			if op&1 == 0 {
+				order := int(op>>1) % (b.MaxOrder + 2)
+				size := 1 << order
+				// With buddies always merged, the allocation succeeds exactly when an aligned run of free units exists.
+				want := false
+				for i := 0; i+size <= len(live) && !want; i += size {
+					want = !slices.Contains(live[i:i+size], true)
+				}
+				block := b.Alloc(order)
+				if (block.Offset != -1) != want {
+					t.Fatalf("alloc order %d: got offset %d, want success %v", order, block.Offset, want)
+				}
+				if block.Offset != -1 {
+					unit := block.Offset / b.MinBlock
+					if block.Length != b.MinBlock<<order || slices.Contains(live[unit:unit+size], true) {
+						t.Fatalf("alloc order %d: got %+v", order, block)
+					}
+					for i := unit; i < unit+size; i++ {
+						live[i] = true
+					}
+					list = append(list, block)
+				}
+			} else if len(list) != 0 {
+				i := int(op>>1) % len(list)
+				block := list[i]
+				if err := b.Close(block); err != nil {
+					t.Fatal(err)
+				}
+				if err := b.Close(block); !errors.Is(err, ErrDoubleFree) {
+					t.Fatalf("double close: got %v", err)
+				}
+				for i := block.Offset / b.MinBlock; i < (block.Offset+block.Length)/b.MinBlock; i++ {
+					live[i] = false
+				}
+				list = slices.Delete(list, i, i+1)
+			}
+			if err := b.Check(); err != nil {
+				t.Fatal(err)
+			}
+			avail := 0
+			for _, e := range live {
+				if !e {
+					avail += b.MinBlock
+				}
+			}
+			if b.Avail() != avail {
+				t.Fatalf("avail %d does not match the model", b.Avail())
+			}
+		}
+	})
+}
+
+func TestBuffer(t *testing.T) {
+	balloc := New(64, 64*1024)
+	balloc.Policy = PolicyError
+	buf := NewBuffer(balloc)
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(rand.Uint32())
+	}
+	if n, err := buf.ReadFrom(bytes.NewReader(data)); n != int64(len(data)) || err != nil {
+		t.FailNow()
+	}
+	head := make([]byte, 100)
+	if n, _ := buf.Read(head); n != 100 || !bytes.Equal(head, data[:100]) {
+		t.FailNow()
+	}
+	buf.WriteString("tail")
+	out := bytes.Buffer{}
+	if n, err := buf.WriteTo(&out); n != int64(len(data))-100+4 || err != nil {
+		t.FailNow()
+	}
+	if !bytes.Equal(out.Bytes(), append(data[100:], "tail"...)) {
+		t.FailNow()
+	}
+	if n, err := buf.Read(head); n != 0 || err != io.EOF {
+		t.FailNow()
+	}
+	if _, err := buf.Write(make([]byte, 128*1024)); !errors.Is(err, ErrExhausted) || buf.Len() != 0 {
+		t.FailNow()
+	}
+	buf.Close()
+	if balloc.Avail() != 64*1024 {
+		t.FailNow()
+	}
+	pool := NewBufferPool(balloc, 256, 2)
+	a := pool.Get()
+	b := pool.Get()
+	c := pool.Get()
+	if a.Cap() != 256 || balloc.Avail() != 64*1024-256*3 {
+		t.FailNow()
+	}
+	a.WriteString("hello")
+	pool.Put(a)
+	pool.Put(b)
+	pool.Put(c)
+	if balloc.Avail() != 64*1024-256*2 {
+		t.FailNow()
+	}
+	if d := pool.Get(); d != b || d.Len() != 0 {
+		t.FailNow()
+	}
+	pool.Put(b)
+	pool.Flush()
+	if balloc.Avail() != 64*1024 {
+		t.FailNow()
+	}
+}
+
+func TestAllocAligned(t *testing.T) {
+	balloc := New(64, 64*1024)
+	a, err := balloc.AllocAligned(100, 4096)
+	if err != nil || len(a) != 100 || cap(a) != 4096 || uintptr(unsafe.Pointer(&a[0]))%4096 != 0 {
+		t.FailNow()
+	}
+	b, err := balloc.AllocAligned(10, 8)
+	if err != nil || cap(b) != 64 {
+		t.FailNow()
+	}
+	for _, e := range [][2]int{{-1, 64}, {64, 0}, {64, 96}, {64, 128 * 1024}, {128 * 1024, 64}} {
+		if _, err := balloc.AllocAligned(e[0], e[1]); err == nil || errors.Is(err, ErrExhausted) {
+			t.Fatal(e)
+		}
+	}
+	c, err := balloc.AllocOrder(2)
+	if err != nil || len(c) != 256 || cap(c) != 256 {
+		t.FailNow()
+	}
+	if _, err := balloc.AllocOrder(11); err == nil {
+		t.FailNow()
+	}
+	if _, err := balloc.AllocOrder(10); !errors.Is(err, ErrExhausted) {
+		t.FailNow()
+	}
+	balloc.Close(a)
+	balloc.Close(b)
+	balloc.Close(c)
+	if balloc.Avail() != 64*1024 {
+		t.FailNow()
+	}
+	// The pool of New is only aligned to the page size of the Go heap.
+	heap := New(64, 4*1024*1024)
+	for _, e := range []int{16 * 1024, 64 * 1024, 1024 * 1024} {
+		a, err := heap.AllocAligned(100, e)
+		if err != nil || len(a) != 100 || uintptr(unsafe.Pointer(&a[0]))%uintptr(e) != 0 {
+			t.Fatal(e, err)
+		}
+		if err := heap.Inner.Check(); err != nil {
+			t.Fatal(err)
+		}
+		if err := heap.Close(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if heap.Avail() != 4*1024*1024 {
+		t.FailNow()
+	}
+	balloc.Guard = true
+	if _, err := balloc.AllocAligned(64, 64); err == nil {
+		t.FailNow()
+	}
+	small := New(16, 1024)
+	small.Guard = true
+	if _, err := small.AllocOrder(0); err == nil {
+		t.FailNow()
+	}
+	d, err := balloc.AllocOrder(1)
+	if err != nil || len(d) != 128-32 {
+		t.FailNow()
+	}
+	if err := balloc.Close(d); err != nil {
+		t.FailNow()
+	}
+}
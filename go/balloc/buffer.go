@@ -0,0 +1,226 @@
+package balloc
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Buffer is a variable-sized buffer of bytes whose memory comes from an Allocator. It grows by reallocating inside the
+// memory pool, so that growing is often done in place. The zero value with Inner set is an empty buffer ready to use.
+// A Buffer must be closed to return its memory to the pool.
+type Buffer struct {
+	Inner *Allocator
+	// Memory of the buffer, the whole block is used. Unread bytes are data[r:w].
+	data []byte
+	r    int
+	w    int
+}
+
+// Minimum number of free bytes made available for each read in ReadFrom.
+const bufferMinRead = 512
+
+// Bytes returns the unread portion of the buffer. The slice is valid until the next modification of the buffer.
+func (b *Buffer) Bytes() []byte {
+	return b.data[b.r:b.w]
+}
+
+// Cap returns the capacity of the buffer.
+func (b *Buffer) Cap() int {
+	return len(b.data)
+}
+
+// Close returns the memory of the buffer to the pool. The buffer is empty afterwards, and can be used again.
+func (b *Buffer) Close() error {
+	data := b.data
+	b.data = nil
+	b.r = 0
+	b.w = 0
+	if data == nil {
+		return nil
+	}
+	return b.Inner.Close(data)
+}
+
+// Grow grows the capacity of the buffer to guarantee space for another n bytes. It returns ErrExhausted if the
+// Allocator can not provide the memory.
+func (b *Buffer) Grow(n int) error {
+	if n < 0 {
+		return errors.New("balloc: negative count")
+	}
+	if len(b.data)-b.w >= n {
+		return nil
+	}
+	if b.r != 0 {
+		// Move the unread bytes to the front, that may leave enough room.
+		copy(b.data, b.data[b.r:b.w])
+		b.w -= b.r
+		b.r = 0
+	}
+	if len(b.data)-b.w >= n {
+		return nil
+	}
+	data, err := b.Inner.Realloc(b.data[:b.w], max(b.w+n, len(b.data)*2))
+	if err != nil {
+		return err
+	}
+	b.data = data[:cap(data)]
+	return nil
+}
+
+// Len returns the number of unread bytes.
+func (b *Buffer) Len() int {
+	return b.w - b.r
+}
+
+// Read reads the next len(p) bytes from the buffer or until the buffer is drained. If the buffer has no data to return,
+// err is io.EOF unless len(p) is zero.
+func (b *Buffer) Read(p []byte) (int, error) {
+	if b.r == b.w {
+		b.Reset()
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, b.data[b.r:b.w])
+	b.r += n
+	return n, nil
+}
+
+// ReadFrom reads data from r until io.EOF and appends it to the buffer, growing the buffer as needed. Any error except
+// io.EOF encountered during the read is returned.
+func (b *Buffer) ReadFrom(r io.Reader) (int64, error) {
+	s := int64(0)
+	for {
+		if err := b.Grow(bufferMinRead); err != nil {
+			return s, err
+		}
+		n, err := r.Read(b.data[b.w:])
+		if n < 0 {
+			return s, errors.New("balloc: reader returned negative count")
+		}
+		b.w += n
+		s += int64(n)
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return s, err
+		}
+	}
+}
+
+// Reset resets the buffer to be empty, but it retains the memory for use by future writes.
+func (b *Buffer) Reset() {
+	b.r = 0
+	b.w = 0
+}
+
+// Write appends the contents of p to the buffer, growing the buffer as needed. If the buffer can not grow, it returns
+// ErrExhausted and nothing is written.
+func (b *Buffer) Write(p []byte) (int, error) {
+	if err := b.Grow(len(p)); err != nil {
+		return 0, err
+	}
+	b.w += copy(b.data[b.w:], p)
+	return len(p), nil
+}
+
+// WriteString appends the contents of s to the buffer, like Write.
+func (b *Buffer) WriteString(s string) (int, error) {
+	if err := b.Grow(len(s)); err != nil {
+		return 0, err
+	}
+	b.w += copy(b.data[b.w:], s)
+	return len(s), nil
+}
+
+// WriteTo writes data to w until the buffer is drained or an error occurs.
+func (b *Buffer) WriteTo(w io.Writer) (int64, error) {
+	s := int64(0)
+	for b.r < b.w {
+		n, err := w.Write(b.data[b.r:b.w])
+		b.r += n
+		s += int64(n)
+		if err != nil {
+			return s, err
+		}
+		if n == 0 {
+			return s, io.ErrShortWrite
+		}
+	}
+	b.Reset()
+	return s, nil
+}
+
+// NewBuffer creates an empty buffer on top of inner.
+func NewBuffer(inner *Allocator) *Buffer {
+	return &Buffer{Inner: inner}
+}
+
+// BufferPool is a set of reusable buffers drawn from a single Allocator, with Get and Put semantics like sync.Pool.
+// Unlike sync.Pool, idle buffers are never dropped silently, since their memory would be lost for the Allocator. At
+// most Idle buffers are kept, the memory of any buffer put beyond that is returned to the Allocator.
+type BufferPool struct {
+	// Idle is the maximum number of idle buffers kept by the pool.
+	Idle  int
+	Inner *Allocator
+	// Size is the initial capacity reserved for a new buffer. The reservation is best effort, a buffer that could not
+	// get its memory will try again on the first write.
+	Size  int
+	mutex *sync.Mutex
+	free  []*Buffer
+}
+
+// Get returns an empty buffer, either an idle one or a new one. This method is thread-safe.
+func (b *BufferPool) Get() *Buffer {
+	b.mutex.Lock()
+	if len(b.free) != 0 {
+		r := b.free[len(b.free)-1]
+		b.free = b.free[:len(b.free)-1]
+		b.mutex.Unlock()
+		return r
+	}
+	b.mutex.Unlock()
+	r := NewBuffer(b.Inner)
+	r.Grow(b.Size)
+	return r
+}
+
+// Put adds a buffer to the pool. The buffer must not be used after it has been put. This method is thread-safe.
+func (b *BufferPool) Put(buf *Buffer) error {
+	buf.Reset()
+	b.mutex.Lock()
+	if len(b.free) < b.Idle {
+		b.free = append(b.free, buf)
+		b.mutex.Unlock()
+		return nil
+	}
+	b.mutex.Unlock()
+	return buf.Close()
+}
+
+// Flush returns the memory of all idle buffers to the Allocator. This method is thread-safe.
+func (b *BufferPool) Flush() error {
+	b.mutex.Lock()
+	free := b.free
+	b.free = nil
+	b.mutex.Unlock()
+	var err error
+	for _, e := range free {
+		err = errors.Join(err, e.Close())
+	}
+	return err
+}
+
+// NewBufferPool creates a buffer pool on top of inner. Each new buffer reserves size bytes, and up to idle buffers are
+// kept for reuse.
+func NewBufferPool(inner *Allocator, size int, idle int) *BufferPool {
+	return &BufferPool{
+		Idle:  idle,
+		Inner: inner,
+		Size:  size,
+		mutex: &sync.Mutex{},
+	}
+}
@@ -0,0 +1,138 @@
+package balloc
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"unsafe"
+)
+
+// A trace describes a live allocation in tracking mode.
+type trace struct {
+	size  int
+	stack []uintptr
+}
+
+// Prefix of the functions of this package, they are left out of the call stacks.
+var tracePkg = reflect.TypeFor[Allocator]().PkgPath() + "."
+
+// Function callers returns the call stack of the current goroutine.
+func callers() []uintptr {
+	pc := make([]uintptr, 32)
+	return pc[:runtime.Callers(2, pc)]
+}
+
+// Function record adds a live allocation in tracking mode.
+func (b *Allocator) record(inner *Algorithm, block Blockinfo, stack []uintptr) {
+	if b.live == nil {
+		b.live = map[uintptr]*trace{}
+	}
+	if stack == nil {
+		stack = callers()
+	}
+	b.live[uintptr(unsafe.Pointer(&inner.PreAlloc[block.Offset]))] = &trace{size: block.Length, stack: stack}
+}
+
+// Function forget removes a live allocation.
+func (b *Allocator) forget(inner *Algorithm, block Blockinfo) {
+	delete(b.live, uintptr(unsafe.Pointer(&inner.PreAlloc[block.Offset])))
+}
+
+// Function resize updates the size of a live allocation that was resized in place.
+func (b *Allocator) resize(inner *Algorithm, block Blockinfo, size int) {
+	if t, ok := b.live[uintptr(unsafe.Pointer(&inner.PreAlloc[block.Offset]))]; ok {
+		t.size = size
+	}
+}
+
+// Function site formats a call stack, without the frames of this package.
+func site(stack []uintptr) string {
+	buf := strings.Builder{}
+	frames := runtime.CallersFrames(stack)
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, tracePkg) || strings.HasSuffix(f.File, "_test.go") {
+			fmt.Fprintf(&buf, "\t%s\n\t\t%s:%d\n", f.Function, f.File, f.Line)
+		}
+		if !more {
+			break
+		}
+	}
+	return buf.String()
+}
+
+// Function leaks writes the report of Leaks and returns the number of live allocations.
+func (b *Allocator) leaks(w io.Writer) (int, error) {
+	type group struct {
+		site  string
+		count int
+		size  int
+	}
+	m := map[string]*group{}
+	size := 0
+	for _, t := range b.live {
+		s := site(t.stack)
+		if m[s] == nil {
+			m[s] = &group{site: s}
+		}
+		m[s].count++
+		m[s].size += t.size
+		size += t.size
+	}
+	l := slices.SortedFunc(func(yield func(*group) bool) {
+		for _, g := range m {
+			if !yield(g) {
+				return
+			}
+		}
+	}, func(a, b *group) int {
+		return cmp.Or(cmp.Compare(b.size, a.size), strings.Compare(a.site, b.site))
+	})
+	if _, err := fmt.Fprintf(w, "balloc: %d live blocks, %d bytes\n", len(b.live), size); err != nil {
+		return 0, err
+	}
+	for _, g := range l {
+		if _, err := fmt.Fprintf(w, "%d bytes in %d blocks allocated at:\n%s", g.size, g.count, g.site); err != nil {
+			return 0, err
+		}
+	}
+	return len(b.live), nil
+}
+
+// Leaks writes a report of the live allocations recorded in tracking mode to w. Allocations are grouped by call site,
+// the largest groups come first. This method is thread-safe.
+func (b *Allocator) Leaks(w io.Writer) error {
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+	_, err := b.leaks(w)
+	return err
+}
+
+// TB is the subset of testing.TB used by LeakCheck.
+type TB interface {
+	Cleanup(func())
+	Errorf(format string, args ...any)
+	Helper()
+}
+
+// LeakCheck turns on tracking mode and fails the test t if allocations of b are still live when the test and its
+// subtests have completed. Call it before the first allocation.
+func LeakCheck(t TB, b *Allocator) {
+	t.Helper()
+	b.Mutex.Lock()
+	b.Track = true
+	b.Mutex.Unlock()
+	t.Cleanup(func() {
+		b.Mutex.Lock()
+		defer b.Mutex.Unlock()
+		buf := bytes.Buffer{}
+		if n, _ := b.leaks(&buf); n != 0 {
+			t.Errorf("%s", buf.String())
+		}
+	})
+}
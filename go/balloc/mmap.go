@@ -0,0 +1,131 @@
+package balloc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"unsafe"
+)
+
+// Memory pools outside of the Go heap.
+//
+// A pool created by NewMmap lives in anonymous memory, so it neither counts toward the garbage collector's pacing nor
+// gets scanned by it. A pool created by NewFile lives in a shared file mapping together with all of the allocator's
+// metadata, which makes the state of the allocator persistent. The file layout is:
+//
+//	page 0                 header: magic, min block, max total and the free list heads
+//	page 1                 free map, followed by the order map
+//	aligned to a page      memory pool
+//
+// All integers are stored in native byte order and size, so a file can only be opened on the architecture that created
+// it. Additional arenas created when MaxArena is greater than one always live on the Go heap and are not persisted.
+
+const (
+	// Page size used for the file layout.
+	mmapPage = 4096
+	// Magic number at the start of a pool file.
+	mmapMagic = "balloc\x00\x01"
+)
+
+// NewMmap creates a new buddy allocator whose memory pool is backed by an anonymous memory mapping. If huge is set, the
+// pool is backed by huge pages where the operating system supports it: an explicit huge page mapping is tried first,
+// then transparent huge pages are requested. The pool is released by Unmap.
+func NewMmap(minBlock int, maxTotal int, huge bool) (*Allocator, error) {
+	if err := params(minBlock, maxTotal); err != nil {
+		return nil, err
+	}
+	pool, err := mmapAnon(maxTotal, huge)
+	if err != nil {
+		return nil, err
+	}
+	order := log2(minBlock, maxTotal)
+	inner := &Algorithm{
+		FreeList: make([]int, order+1),
+		FreeMap:  make([]uint8, maxTotal/minBlock),
+		MaxOrder: order,
+		MaxTotal: maxTotal,
+		MinBlock: minBlock,
+		OrderMap: make([]uint8, maxTotal/minBlock),
+		PreAlloc: pool,
+	}
+	inner.reset()
+	b := newAllocator(inner)
+	b.unmap = func() error {
+		return munmap(pool)
+	}
+	return b, nil
+}
+
+// NewFile creates a new buddy allocator whose memory pool and metadata are backed by a shared mapping of the file at
+// path. If the file is empty or does not exist, it is initialized with an empty pool. Otherwise the allocator state
+// stored in the file is restored, in which case minBlock and maxTotal must match the values the file was created
+// with. The restored state is verified by Algorithm.Check. The state is written back to the file, and synced to disk,
+// by Unmap.
+//
+// The file must not be opened by more than one allocator at a time.
+func NewFile(path string, minBlock int, maxTotal int) (*Allocator, error) {
+	if err := params(minBlock, maxTotal); err != nil {
+		return nil, err
+	}
+	units := maxTotal / minBlock
+	order := log2(minBlock, maxTotal)
+	if mmapPage < 24+(order+1)*isize {
+		return nil, errors.New("balloc: too many orders for the file header")
+	}
+	start := (mmapPage + units*2 + mmapPage - 1) / mmapPage * mmapPage
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	fresh := info.Size() == 0
+	if fresh {
+		if err := f.Truncate(int64(start + maxTotal)); err != nil {
+			return nil, err
+		}
+	} else if info.Size() != int64(start+maxTotal) {
+		return nil, fmt.Errorf("balloc: file size %d does not match the pool size", info.Size())
+	}
+	data, err := mmapFile(f, start+maxTotal)
+	if err != nil {
+		return nil, err
+	}
+	head := data[:mmapPage]
+	if fresh {
+		copy(head[0:8], mmapMagic)
+		binary.NativeEndian.PutUint64(head[8:16], uint64(minBlock))
+		binary.NativeEndian.PutUint64(head[16:24], uint64(maxTotal))
+	}
+	if string(head[0:8]) != mmapMagic ||
+		binary.NativeEndian.Uint64(head[8:16]) != uint64(minBlock) ||
+		binary.NativeEndian.Uint64(head[16:24]) != uint64(maxTotal) {
+		munmap(data)
+		return nil, errors.New("balloc: file was not created with the same parameters")
+	}
+	inner := &Algorithm{
+		FreeList: unsafe.Slice((*int)(unsafe.Pointer(&head[24])), order+1),
+		FreeMap:  data[mmapPage : mmapPage+units : mmapPage+units],
+		MaxOrder: order,
+		MaxTotal: maxTotal,
+		MinBlock: minBlock,
+		OrderMap: data[mmapPage+units : mmapPage+units*2 : mmapPage+units*2],
+		PreAlloc: data[start : start+maxTotal : start+maxTotal],
+	}
+	if fresh {
+		inner.reset()
+	}
+	if err := inner.Check(); err != nil {
+		munmap(data)
+		return nil, err
+	}
+	b := newAllocator(inner)
+	b.unmap = func() error {
+		return errors.Join(msync(data), munmap(data))
+	}
+	return b, nil
+}
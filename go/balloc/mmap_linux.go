@@ -0,0 +1,15 @@
+//go:build !arm
+
+package balloc
+
+import (
+	"syscall"
+)
+
+// Flag for an explicit huge page mapping.
+const mmapHuge = syscall.MAP_HUGETLB
+
+// Function madvHuge asks for transparent huge pages. It is only a hint, the mapping is usable either way.
+func madvHuge(data []byte) {
+	syscall.Madvise(data, syscall.MADV_HUGEPAGE)
+}
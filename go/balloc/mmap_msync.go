@@ -0,0 +1,18 @@
+//go:build darwin || dragonfly || freebsd || linux || openbsd
+
+package balloc
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Function msync writes the changes made to a file mapping back to the file, and waits for the write to complete.
+func msync(data []byte) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(unsafe.SliceData(data))), uintptr(len(data)),
+		syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !(darwin || dragonfly || freebsd || linux || openbsd)
+
+package balloc
+
+// Function msync does nothing, package syscall provides no msync on this platform. The operating system writes the
+// changes made to a file mapping back to the file on its own, after munmap.
+func msync(data []byte) error {
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package balloc
+
+import (
+	"errors"
+	"os"
+)
+
+// Function mmapAnon is not supported on this platform.
+func mmapAnon(size int, huge bool) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// Function mmapFile is not supported on this platform.
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// Function munmap is not supported on this platform.
+func munmap(data []byte) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,9 @@
+//go:build unix && !(linux && !arm)
+
+package balloc
+
+// Explicit huge page mappings are not supported, package syscall does not define MAP_HUGETLB on linux/arm.
+const mmapHuge = 0
+
+// Function madvHuge does nothing, transparent huge pages are not supported.
+func madvHuge(data []byte) {}
@@ -0,0 +1,38 @@
+//go:build unix
+
+package balloc
+
+import (
+	"os"
+	"syscall"
+)
+
+// Function mmapAnon maps size bytes of anonymous memory.
+func mmapAnon(size int, huge bool) ([]byte, error) {
+	prot := syscall.PROT_READ | syscall.PROT_WRITE
+	flag := syscall.MAP_ANON | syscall.MAP_PRIVATE
+	if huge && mmapHuge != 0 {
+		data, err := syscall.Mmap(-1, 0, size, prot, flag|mmapHuge)
+		if err == nil {
+			return data, nil
+		}
+	}
+	data, err := syscall.Mmap(-1, 0, size, prot, flag)
+	if err != nil {
+		return nil, err
+	}
+	if huge {
+		madvHuge(data)
+	}
+	return data, nil
+}
+
+// Function mmapFile maps the first size bytes of f as shared memory.
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+}
+
+// Function munmap releases a mapping created by mmapAnon or mmapFile.
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,225 @@
+package balloc
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Sharded is a buddy allocator front end for highly concurrent workloads. It keeps per-shard caches of small blocks,
+// so most Alloc and Close calls only touch the lock of one shard instead of the lock of the central Allocator. Caches
+// are refilled from and returned to the central Allocator in batches.
+//
+// Only blocks of the central allocator's primary arena are cached. Large allocations and allocations made while the
+// primary arena is exhausted are passed through to the central Allocator. The Guard and Track settings of the central
+// allocator are read once by NewSharded: if either is on, the caches are bypassed altogether.
+//
+// Under PolicyBlock, a caller blocked by the central allocator makes the shards return their caches to it, and blocks
+// closed while callers are blocked go to them instead of a cache.
+type Sharded struct {
+	// Batch is the number of blocks moved between a shard and the central allocator at once. A shard holds at most
+	// 2*Batch blocks of each order.
+	Batch int
+	Inner *Allocator
+	// Small is the largest order that is cached by the shards.
+	Small int
+	// Bitmap of the blocks sitting in shard caches, indexed by Offset/MinBlock. It tells a cached block from a live
+	// one, since the central allocator sees both as live.
+	cache []atomic.Uint64
+	// Order plus one of the live blocks handed out from the shard caches, a byte per MinBlock unit packed eight to a
+	// word. Zero for any other block, which is closed by the central allocator.
+	order []atomic.Uint64
+	// Whether the caches are bypassed, because the central allocator is in guard or tracking mode.
+	bypass bool
+	// Pool of shards, used as a per-P hint so that a goroutine keeps using the shard of the processor it runs on. The
+	// shards themselves are owned by the shard slice, so nothing is lost when the pool is cleared.
+	local sync.Pool
+	shard []*shard
+	index atomic.Uint32
+}
+
+// A shard caches free blocks of small orders.
+type shard struct {
+	mutex sync.Mutex
+	block [][]int
+	// Keep shards on different cache lines.
+	_ [64]byte
+}
+
+// Alloc allocates a byte slice of the requested size, preferably from the cache of a shard. This method is
+// thread-safe.
+func (b *Sharded) Alloc(size int) []byte {
+	inner := b.Inner.Inner
+	order := log2(inner.MinBlock, max(inner.MinBlock, npo2(size)))
+	if order > b.Small || order > inner.MaxOrder || b.bypass {
+		return b.Inner.Alloc(size)
+	}
+	s := b.pick()
+	if len(s.block[order]) == 0 {
+		b.Inner.Mutex.Lock()
+		// Blocked callers of the central allocator are served first.
+		for i := 0; i < b.Batch && len(b.Inner.wait) == 0; i++ {
+			block := inner.Alloc(order)
+			if block.Offset == -1 {
+				break
+			}
+			b.mark(block.Offset)
+			b.Inner.account(block.Length)
+			s.block[order] = append(s.block[order], block.Offset)
+		}
+		b.Inner.Mutex.Unlock()
+	}
+	if len(s.block[order]) == 0 {
+		// The central allocator may block, which must not happen with the shard locked.
+		b.done(s)
+		return b.Inner.Alloc(size)
+	}
+	blockOffset := s.block[order][len(s.block[order])-1]
+	s.block[order] = s.block[order][:len(s.block[order])-1]
+	b.done(s)
+	b.unmark(blockOffset)
+	b.order[blockOffset/inner.MinBlock/8].Or(uint64(order+1) << (blockOffset / inner.MinBlock % 8 * 8))
+	return inner.PreAlloc[blockOffset : blockOffset+size : blockOffset+inner.MinBlock<<order]
+}
+
+// Avail returns the total available memory, including the blocks cached by the shards. This method is thread-safe.
+func (b *Sharded) Avail() int {
+	s := 0
+	for _, e := range b.shard {
+		e.mutex.Lock()
+		for order, l := range e.block {
+			s += len(l) * b.Inner.Inner.MinBlock << order
+		}
+		e.mutex.Unlock()
+	}
+	return s + b.Inner.Avail()
+}
+
+// Close returns a previously allocated byte slice to the cache of a shard, or to the central allocator. The same
+// errors as Allocator.Close are reported. This method is thread-safe.
+func (b *Sharded) Close(data []byte) error {
+	inner := b.Inner.Inner
+	if cap(data) == 0 || b.bypass || !contains(inner, unsafe.SliceData(data)) {
+		return b.Inner.Close(data)
+	}
+	blockOffset := int(uintptr(unsafe.Pointer(unsafe.SliceData(data))) - uintptr(unsafe.Pointer(&inner.PreAlloc[0])))
+	if blockOffset%inner.MinBlock != 0 {
+		return b.Inner.Close(data)
+	}
+	// Clear the order of the block, so that of two concurrent closes only one finds it.
+	i := blockOffset / inner.MinBlock
+	order := int(b.order[i/8].And(^(uint64(0xff)<<(i%8*8)))>>(i%8*8)&0xff) - 1
+	if order < 0 {
+		if b.cached(blockOffset) {
+			b.Inner.Mutex.Lock()
+			defer b.Inner.Mutex.Unlock()
+			return b.Inner.fail(fmt.Errorf("%w: offset %d", ErrDoubleFree, blockOffset), data)
+		}
+		// Not handed out by a shard, the central allocator knows the block or reports the proper error.
+		return b.Inner.Close(data)
+	}
+	b.mark(blockOffset)
+	s := b.pick()
+	defer b.done(s)
+	if b.Inner.parked.Load() != 0 {
+		// Callers are blocked in the central allocator, the block goes to them rather than to the cache.
+		b.drain([]int{blockOffset}, order)
+		return nil
+	}
+	s.block[order] = append(s.block[order], blockOffset)
+	if len(s.block[order]) > b.Batch*2 {
+		n := len(s.block[order]) - b.Batch
+		b.drain(s.block[order][n:], order)
+		s.block[order] = s.block[order][:n]
+	}
+	return nil
+}
+
+// Flush returns all cached blocks to the central allocator. This method is thread-safe.
+func (b *Sharded) Flush() {
+	for _, s := range b.shard {
+		s.mutex.Lock()
+		for order, l := range s.block {
+			b.drain(l, order)
+			s.block[order] = s.block[order][:0]
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// Function drain returns cached blocks of the given order to the central allocator.
+func (b *Sharded) drain(l []int, order int) {
+	inner := b.Inner.Inner
+	b.Inner.Mutex.Lock()
+	defer b.Inner.Mutex.Unlock()
+	for _, blockOffset := range l {
+		b.unmark(blockOffset)
+		inner.Close(Blockinfo{Offset: blockOffset, Length: inner.MinBlock << order})
+		b.Inner.account(-inner.MinBlock << order)
+	}
+	b.Inner.wake()
+}
+
+// Function mark flags a block as cached. It returns false if the block was already cached.
+func (b *Sharded) mark(blockOffset int) bool {
+	i := blockOffset / b.Inner.Inner.MinBlock
+	m := uint64(1) << (i % 64)
+	return b.cache[i/64].Or(m)&m == 0
+}
+
+// Function cached reports whether a block is cached.
+func (b *Sharded) cached(blockOffset int) bool {
+	i := blockOffset / b.Inner.Inner.MinBlock
+	return b.cache[i/64].Load()&(uint64(1)<<(i%64)) != 0
+}
+
+// Function unmark clears the cached flag of a block.
+func (b *Sharded) unmark(blockOffset int) {
+	i := blockOffset / b.Inner.Inner.MinBlock
+	b.cache[i/64].And(^(uint64(1) << (i % 64)))
+}
+
+// Function pick locks and returns a shard, preferably the one last used on the current processor.
+func (b *Sharded) pick() *shard {
+	s := b.local.Get().(*shard)
+	s.mutex.Lock()
+	return s
+}
+
+// Function done unlocks a shard returned by pick.
+func (b *Sharded) done(s *shard) {
+	s.mutex.Unlock()
+	b.local.Put(s)
+}
+
+// NewSharded creates a sharded front end for the central allocator inner. If shards is zero, one shard per CPU is
+// created. Guard and Track must be set on inner before.
+func NewSharded(inner *Allocator, shards int) *Sharded {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+	inner.Mutex.Lock()
+	bypass := inner.Guard || inner.Track
+	inner.Mutex.Unlock()
+	b := &Sharded{
+		Batch:  32,
+		Inner:  inner,
+		Small:  min(3, inner.Inner.MaxOrder),
+		cache:  make([]atomic.Uint64, (len(inner.Inner.OrderMap)+63)/64),
+		order:  make([]atomic.Uint64, (len(inner.Inner.OrderMap)+7)/8),
+		bypass: bypass,
+		shard:  make([]*shard, shards),
+	}
+	for i := range b.shard {
+		b.shard[i] = &shard{block: make([][]int, inner.Inner.MaxOrder+1)}
+	}
+	inner.Mutex.Lock()
+	inner.reclaim = b.Flush
+	inner.Mutex.Unlock()
+	b.local.New = func() any {
+		return b.shard[int(b.index.Add(1))%len(b.shard)]
+	}
+	return b
+}
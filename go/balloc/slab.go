@@ -0,0 +1,167 @@
+package balloc
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"math/bits"
+	"reflect"
+	"slices"
+	"sync"
+	"unsafe"
+)
+
+// Slab is a typed allocator for fixed-size objects layered on an Allocator. It carves buddy blocks, the slabs, into
+// slots of exactly the size of T, which avoids the power of two rounding of the buddy allocator. Slabs are taken from
+// the Allocator on demand and returned to it as soon as they become empty.
+//
+// The memory of the pool is not scanned by the garbage collector, which is what makes a slab of millions of objects
+// cheap. The flip side is that a pointer stored in the pool does not keep its target alive, and would dangle once the
+// garbage collector frees it, so T must not contain pointers. Such types belong on the Go heap.
+type Slab[T any] struct {
+	Inner *Allocator
+	// Number of slots in a slab, and the size of a slot in bytes.
+	count int
+	slot  int
+	mutex *sync.Mutex
+	// All slabs sorted by address, and the slabs that have at least one free slot.
+	list []*slab
+	part []*slab
+}
+
+// A slab is a buddy block divided into slots.
+type slab struct {
+	data []byte
+	// Free slots bitmap, a set bit means the slot is free.
+	free []uint64
+	used int
+}
+
+// Function addr returns the address of the first byte of the slab.
+func (s *slab) addr() uintptr {
+	return uintptr(unsafe.Pointer(unsafe.SliceData(s.data)))
+}
+
+// Get returns a pointer to a zeroed object. A new slab is taken from the Allocator like TryAlloc does, it neither falls
+// back to the heap nor blocks: if the memory is not available, ErrExhausted is returned. This method is thread-safe.
+func (b *Slab[T]) Get() (*T, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if len(b.part) == 0 {
+		data, err := b.Inner.TryAlloc(b.count * b.slot)
+		if err != nil {
+			return nil, err
+		}
+		s := &slab{
+			data: data,
+			free: make([]uint64, (b.count+63)/64),
+		}
+		for i := range b.count {
+			s.free[i/64] |= 1 << (i % 64)
+		}
+		i, _ := slices.BinarySearchFunc(b.list, s.addr(), func(e *slab, a uintptr) int {
+			return cmp.Compare(e.addr(), a)
+		})
+		b.list = slices.Insert(b.list, i, s)
+		b.part = append(b.part, s)
+	}
+	s := b.part[len(b.part)-1]
+	i := 0
+	for j, w := range s.free {
+		if w != 0 {
+			i = j*64 + bits.TrailingZeros64(w)
+			break
+		}
+	}
+	s.free[i/64] &^= 1 << (i % 64)
+	s.used++
+	if s.used == b.count {
+		b.part = b.part[:len(b.part)-1]
+	}
+	data := s.data[i*b.slot : (i+1)*b.slot]
+	clear(data)
+	return (*T)(unsafe.Pointer(&data[0])), nil
+}
+
+// Put returns an object obtained from Get. Pointers that do not point to a live object of this Slab are reported as
+// errors, or cause a panic in debug mode. This method is thread-safe.
+func (b *Slab[T]) Put(p *T) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	err := b.put(uintptr(unsafe.Pointer(p)))
+	if err != nil && b.Inner.Debug {
+		log.Panicln(err)
+	}
+	return err
+}
+
+func (b *Slab[T]) put(a uintptr) error {
+	i, ok := slices.BinarySearchFunc(b.list, a, func(e *slab, a uintptr) int {
+		return cmp.Compare(e.addr(), a)
+	})
+	if !ok {
+		i--
+	}
+	if i < 0 || a >= b.list[i].addr()+uintptr(b.count*b.slot) {
+		return fmt.Errorf("%w: address %#x", ErrForeign, a)
+	}
+	s := b.list[i]
+	if (a-s.addr())%uintptr(b.slot) != 0 {
+		return fmt.Errorf("%w: address %#x", ErrInterior, a)
+	}
+	j := int(a-s.addr()) / b.slot
+	if s.free[j/64]&(1<<(j%64)) != 0 {
+		return fmt.Errorf("%w: address %#x", ErrDoubleFree, a)
+	}
+	s.free[j/64] |= 1 << (j % 64)
+	s.used--
+	if s.used == b.count-1 {
+		b.part = append(b.part, s)
+	}
+	if s.used == 0 {
+		b.list = slices.Delete(b.list, i, i+1)
+		b.part = slices.DeleteFunc(b.part, func(e *slab) bool { return e == s })
+		return b.Inner.Close(s.data)
+	}
+	return nil
+}
+
+// Function pointers reports whether values of type t contain pointers.
+func pointers(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Array:
+		return t.Len() > 0 && pointers(t.Elem())
+	case reflect.Struct:
+		for i := range t.NumField() {
+			if pointers(t.Field(i).Type) {
+				return true
+			}
+		}
+		return false
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Pointer, reflect.Slice, reflect.String,
+		reflect.UnsafePointer:
+		return true
+	}
+	return false
+}
+
+// NewSlab creates a slab allocator for objects of type T on top of inner. Each slab holds up to count objects, rounded
+// up to fill its buddy block. It panics if T contains pointers, see Slab.
+func NewSlab[T any](inner *Allocator, count int) *Slab[T] {
+	t := reflect.TypeFor[T]()
+	if pointers(t) {
+		log.Panicln("balloc: slab type contains pointers:", t)
+	}
+	slot := int(t.Size())
+	slot = max(1, (slot+t.Align()-1)/t.Align()*t.Align())
+	size := max(inner.Inner.MinBlock, npo2(slot*max(1, count)))
+	if size > inner.Inner.MaxTotal {
+		log.Panicln("balloc: slab is larger than the pool")
+	}
+	return &Slab[T]{
+		Inner: inner,
+		count: size / slot,
+		slot:  slot,
+		mutex: &sync.Mutex{},
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/mohanson/libraries/go/pretty"
+)
+
+func main() {
+	progress := pretty.NewMultiProgress()
+	group := sync.WaitGroup{}
+	for i := range 4 {
+		bar := progress.Add(fmt.Sprintf("file-%d.tar.gz", i))
+		group.Go(func() {
+			bar.Print(0)
+			size := 256 + rand.IntN(768)
+			for j := range size {
+				time.Sleep(time.Millisecond * 4)
+				bar.Print(float64(j+1) / float64(size))
+			}
+		})
+	}
+	group.Wait()
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"flag"
+	"log"
+	"os"
+
 	"github.com/mohanson/libraries/go/pretty"
 )
 
 func main() {
+	format := flag.String("format", "text", "output format: text, csv, tsv, markdown, html or json")
+	flag.Parse()
 	table := pretty.NewTable()
 	table.Head = []string{"City name", "Area", "Population", "Annual Rainfall"}
 	table.Conf = []string{"<", ">", ">", ">"}
@@ -17,5 +23,24 @@ func main() {
 		{"Perth", "5386", "1554769", "869.4"},
 		{"Sydney", "2058", "4336374", "1214.8"},
 	}
-	table.Print()
+	var err error
+	switch *format {
+	case "text":
+		table.Print()
+	case "csv":
+		err = table.CSV(os.Stdout)
+	case "tsv":
+		err = table.TSV(os.Stdout)
+	case "markdown":
+		err = table.Markdown(os.Stdout)
+	case "html":
+		err = table.HTML(os.Stdout)
+	case "json":
+		err = table.JSON(os.Stdout)
+	default:
+		log.Panicln("main: unknown format:", *format)
+	}
+	if err != nil {
+		log.Panicln("main:", err)
+	}
 }
@@ -0,0 +1,185 @@
+package pretty
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Encoders write the data of a Table in machine readable formats. Borders, padding and ANSI escape sequences such as
+// colors are left out, cells are written as they are otherwise.
+
+// Function plain removes ANSI escape sequences from s.
+func plain(s string) string {
+	if !strings.Contains(s, "\x1b[") {
+		return s
+	}
+	buf := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0x1b && i+1 < len(s) && s[i+1] == '[' {
+			i += 2
+			for i < len(s) && (s[i] < 0x40 || s[i] > 0x7e) {
+				i++
+			}
+			continue
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String()
+}
+
+// Function cells returns the cells of a row, plain and completed to the number of columns.
+func (t *Table) cells(row []string) []string {
+	r := make([]string, len(t.Head))
+	for i := range r {
+		if i < len(row) {
+			r[i] = plain(row[i])
+		}
+	}
+	return r
+}
+
+// Function conf returns the alignment of each column, or an error if an alignment is invalid.
+func (t *Table) conf() ([]string, error) {
+	conf := slices.Clone(t.Conf)
+	for range len(t.Head) - len(conf) {
+		conf = append(conf, "<")
+	}
+	for i, c := range conf {
+		if c != "<" && c != ">" && c != "^" {
+			return nil, fmt.Errorf("pretty: invalid alignment %q for column %d", c, i)
+		}
+	}
+	return conf, nil
+}
+
+// Function delimited writes the table as records separated by comma.
+func (t *Table) delimited(w io.Writer, comma rune) error {
+	c := csv.NewWriter(w)
+	c.Comma = comma
+	c.Write(t.cells(t.Head))
+	for _, e := range t.Body {
+		c.Write(t.cells(e))
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// CSV writes the table to w as comma separated values, the head being the first record. Fields are quoted following
+// the rules of encoding/csv.
+func (t *Table) CSV(w io.Writer) error {
+	return t.delimited(w, ',')
+}
+
+// TSV writes the table to w as tab separated values, like CSV.
+func (t *Table) TSV(w io.Writer) error {
+	return t.delimited(w, '\t')
+}
+
+// Markdown writes the table to w as a GitHub flavored Markdown table. The alignment row follows Conf. Pipes are escaped,
+// so are the HTML special characters <, > and &, and line breaks are written as <br>.
+func (t *Table) Markdown(w io.Writer) error {
+	conf, err := t.conf()
+	if err != nil {
+		return err
+	}
+	escape := strings.NewReplacer(
+		"\\", "\\\\", "|", "\\|", "<", "&lt;", ">", "&gt;", "&", "&amp;", "\r\n", "<br>", "\n", "<br>",
+	)
+	row := func(cell []string) string {
+		for i, c := range cell {
+			cell[i] = escape.Replace(c)
+		}
+		return "| " + strings.Join(cell, " | ") + " |\n"
+	}
+	buf := strings.Builder{}
+	buf.WriteString(row(t.cells(t.Head)))
+	line := make([]string, len(t.Head))
+	for i, c := range conf {
+		switch c {
+		case "<":
+			line[i] = ":---"
+		case ">":
+			line[i] = "---:"
+		case "^":
+			line[i] = ":---:"
+		}
+	}
+	buf.WriteString("| " + strings.Join(line, " | ") + " |\n")
+	for _, e := range t.Body {
+		buf.WriteString(row(t.cells(e)))
+	}
+	_, err = io.WriteString(w, buf.String())
+	return err
+}
+
+// HTML writes the table to w as an HTML table element. Cells are escaped, and aligned following Conf.
+func (t *Table) HTML(w io.Writer) error {
+	conf, err := t.conf()
+	if err != nil {
+		return err
+	}
+	align := map[string]string{"<": "", ">": ` style="text-align: right"`, "^": ` style="text-align: center"`}
+	row := func(tag string, cell []string) string {
+		buf := strings.Builder{}
+		buf.WriteString("<tr>")
+		for i, c := range cell {
+			fmt.Fprintf(&buf, "<%s%s>%s</%s>", tag, align[conf[i]], html.EscapeString(c), tag)
+		}
+		buf.WriteString("</tr>\n")
+		return buf.String()
+	}
+	buf := strings.Builder{}
+	buf.WriteString("<table>\n<thead>\n")
+	buf.WriteString(row("th", t.cells(t.Head)))
+	buf.WriteString("</thead>\n<tbody>\n")
+	for _, e := range t.Body {
+		buf.WriteString(row("td", t.cells(e)))
+	}
+	buf.WriteString("</tbody>\n</table>\n")
+	_, err = io.WriteString(w, buf.String())
+	return err
+}
+
+// JSON writes the table to w as a JSON array with an object for each row of the body. The keys of the objects are the
+// head of the table, in the order of the columns. An error is returned if two columns have the same name.
+func (t *Table) JSON(w io.Writer) error {
+	head := t.cells(t.Head)
+	for i, c := range head {
+		if slices.Contains(head[:i], c) {
+			return fmt.Errorf("pretty: duplicate column name %q", c)
+		}
+	}
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	// The encoder terminates each value with a newline, which is dropped.
+	str := func(s string) {
+		enc.Encode(s)
+		buf.Truncate(buf.Len() - 1)
+	}
+	buf.WriteByte('[')
+	for i, e := range t.Body {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('{')
+		for j, c := range t.cells(e) {
+			if j != 0 {
+				buf.WriteByte(',')
+			}
+			str(head[j])
+			buf.WriteByte(':')
+			str(c)
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteString("]\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
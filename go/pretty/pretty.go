@@ -3,54 +3,176 @@ package pretty
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"slices"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 )
 
+// Every renderer has a Logger field used by its Print method. If it is nil, the standard logger is used and lines are
+// prefixed with "pretty:". Set it to a custom logger to change the destination, the prefix or the timestamp of the
+// output. Use the Fprint and String methods to get the output without any decoration.
+
+// Function output prints a line through l.
+func output(l *log.Logger, line string) {
+	if l != nil {
+		l.Println(line)
+		return
+	}
+	log.Println("pretty:", line)
+}
+
+// Function escape writes a terminal escape sequence to the destination of l.
+func escape(l *log.Logger, b []byte) {
+	if l != nil {
+		l.Writer().Write(b)
+		return
+	}
+	log.Writer().Write(b)
+}
+
 // Progress represents a progress bar in the terminal.
+//
+// When the size of the task is not known, Pulse shows an animation instead of the bar. Print switches back to the bar
+// as soon as the percentage is known.
 type Progress struct {
+	// Logger is used by Print and Pulse. If it is nil, the standard logger is used with the "pretty:" prefix. A bar that
+	// belongs to a MultiProgress uses the Logger of it.
+	Logger  *log.Logger
 	chardev bool
 	current float64
+	// Whether the bar has been drawn once, so that the cursor position has been saved.
+	drawn bool
+	// The container of the bar and the name of the bar in it, if the bar belongs to a MultiProgress. Both are set once
+	// by Add. A bar that has been removed from its container keeps it, but is not drawn anymore.
+	group *MultiProgress
+	name  string
+	gone  bool
+	// Renders the text around the bar, if set.
+	info func(bar string) string
+	// Indeterminate mode, and the position of the animation.
+	spin  bool
+	frame int
+	// The time the bar was last drawn.
+	stamp time.Time
 }
 
-// Update updates the progress bar to the specified percent (0 to 1).
+// Print updates the progress bar to the specified percent (0 to 1). A percent out of range is clamped, and a percent
+// lower than the current one moves the bar back.
 func (p *Progress) Print(percent float64) {
-	if percent > 1 {
-		log.Panicln("pretty: the percent cannot be greater than 1")
-	}
-	if percent < p.current {
-		log.Panicln("pretty: the percent cannot be decreased")
+	if p.group != nil {
+		p.group.mutex.Lock()
+		defer p.group.mutex.Unlock()
 	}
-	if percent != 0 && percent != 1 && percent-p.current < 0.01 {
-		// Only update if the change is significant to avoid flickering.
+	percent = min(1, max(0, percent))
+	if !p.spin && percent != 0 && percent != 1 && percent >= p.current && percent-p.current < 0.01 &&
+		(p.info == nil || time.Since(p.stamp) < p.rate()) {
+		// Only update if the change is significant to avoid flickering. The text around the bar may show a rate or a
+		// time, which is refreshed at the pace of Pulse regardless.
 		return
 	}
-	if percent == 1 && percent == p.current {
+	if !p.spin && percent == 1 && percent == p.current {
 		// No need to update if already at 100%.
 		return
 	}
-	if percent == 0 && p.chardev {
+	p.spin = false
+	p.stamp = time.Now()
+	p.current = percent
+	p.draw()
+}
+
+// Function rate returns the minimum time between two redraws of an animation, or of a bar with the same percentage.
+func (p *Progress) rate() time.Duration {
+	if p.chardev {
+		return time.Millisecond * 100
+	}
+	return time.Second
+}
+
+// Pulse shows that a task of unknown size is making progress by advancing an animation in place of the bar. The
+// animation is redrawn at most 10 times per second on a terminal, and once per second otherwise.
+func (p *Progress) Pulse() {
+	if p.group != nil {
+		p.group.mutex.Lock()
+		defer p.group.mutex.Unlock()
+	}
+	if p.spin && time.Since(p.stamp) < p.rate() {
+		return
+	}
+	if p.spin {
+		p.frame++
+	}
+	p.spin = true
+	p.stamp = time.Now()
+	p.current = 0
+	p.draw()
+}
+
+// Function draw outputs the bar.
+func (p *Progress) draw() {
+	if p.group != nil {
+		if !p.gone {
+			p.group.render(p)
+		}
+		return
+	}
+	if p.chardev && !p.drawn {
 		// Save cursor position.
-		log.Writer().Write([]byte{0x1b, 0x37})
+		escape(p.Logger, []byte{0x1b, 0x37})
 	}
-	if percent != 0 && p.chardev {
+	if p.chardev && p.drawn {
 		// Load cursor position.
-		log.Writer().Write([]byte{0x1b, 0x38})
+		escape(p.Logger, []byte{0x1b, 0x38})
 	}
-	p.current = percent
-	cap := int(percent * 44)
+	p.drawn = true
+	output(p.Logger, p.line())
+}
+
+// Fprint writes the current state of the bar to w as a single line.
+func (p *Progress) Fprint(w io.Writer) error {
+	_, err := io.WriteString(w, p.String())
+	return err
+}
+
+// String returns the current state of the bar as written by Fprint.
+func (p *Progress) String() string {
+	if p.group != nil {
+		p.group.mutex.Lock()
+		defer p.group.mutex.Unlock()
+	}
+	return p.line() + "\n"
+}
+
+// Function line renders the bar.
+func (p *Progress) line() string {
 	buf := []byte("[                                             ] 000%")
-	for i := 1; i < cap+1; i++ {
-		buf[i] = '='
+	if p.spin {
+		// A marker bouncing between both ends of the bar.
+		pos := p.frame % 84
+		if pos > 42 {
+			pos = 84 - pos
+		}
+		copy(buf[1+pos:], "<=>")
+		copy(buf[48:], "  ?")
+	} else {
+		cap := int(p.current * 44)
+		for i := 1; i < cap+1; i++ {
+			buf[i] = '='
+		}
+		buf[1+cap] = '>'
+		num := fmt.Sprintf("%3d", int(p.current*100))
+		buf[48] = num[0]
+		buf[49] = num[1]
+		buf[50] = num[2]
 	}
-	buf[1+cap] = '>'
-	num := fmt.Sprintf("%3d", int(percent*100))
-	buf[48] = num[0]
-	buf[49] = num[1]
-	buf[50] = num[2]
-	log.Println("pretty:", string(buf))
+	if p.info != nil {
+		return p.info(string(buf))
+	}
+	return string(buf)
 }
 
 // NewProgress creates a new Progress instance.
@@ -66,22 +188,366 @@ func NewProgress() *Progress {
 	}
 }
 
+// MultiProgress renders several named progress bars at once, for example one per parallel download. Bars can be added
+// and removed at any time, and are updated through their Print method from any goroutine. On a terminal all bars are
+// redrawn in place on every update. When stdout is a pipe or a file, the bars are printed as lines at most once per
+// Interval, and a bar is printed as soon as it reaches 100%.
+type MultiProgress struct {
+	// Interval is the minimum time between two outputs when stdout is not a terminal.
+	Interval time.Duration
+	// Logger is used to draw the bars. If it is nil, the standard logger is used with the "pretty:" prefix.
+	Logger  *log.Logger
+	chardev bool
+	// Number of lines drawn by the last render on a terminal.
+	drawn int
+	// Time of the last output when stdout is not a terminal.
+	last  time.Time
+	list  []*Progress
+	mutex *sync.Mutex
+}
+
+// Add adds a new bar with the given name at the bottom, and returns it. The name must be unique.
+func (m *MultiProgress) Add(name string) *Progress {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if slices.ContainsFunc(m.list, func(p *Progress) bool { return p.name == name }) {
+		log.Panicln("pretty: duplicate progress name:", name)
+	}
+	p := &Progress{chardev: m.chardev, group: m, name: name}
+	m.list = append(m.list, p)
+	if m.chardev {
+		m.render(p)
+	}
+	return p
+}
+
+// Get returns the bar with the given name, or nil if there is no such bar.
+func (m *MultiProgress) Get(name string) *Progress {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, p := range m.list {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Remove removes the bar with the given name. Removing a bar that does not exist does nothing. Updates of a removed bar
+// are not drawn anymore.
+func (m *MultiProgress) Remove(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := slices.IndexFunc(m.list, func(p *Progress) bool { return p.name == name })
+	if i == -1 {
+		return
+	}
+	m.list[i].gone = true
+	m.list = slices.Delete(m.list, i, i+1)
+	if m.chardev {
+		m.render(nil)
+	}
+}
+
+// Function render outputs the bars after bar p has changed.
+func (m *MultiProgress) render(p *Progress) {
+	if !m.chardev {
+		if p != nil && p.current == 1 {
+			output(m.Logger, m.label(p))
+			return
+		}
+		if time.Since(m.last) < m.Interval {
+			return
+		}
+		m.last = time.Now()
+		for _, e := range m.list {
+			output(m.Logger, m.label(e))
+		}
+		return
+	}
+	if m.drawn != 0 {
+		// Move the cursor up to the first bar and erase everything below it.
+		escape(m.Logger, fmt.Appendf(nil, "\x1b[%dA\x1b[J", m.drawn))
+	}
+	for _, e := range m.list {
+		output(m.Logger, m.label(e))
+	}
+	m.drawn = len(m.list)
+}
+
+// Function label renders a bar with its name, padded to the longest name.
+func (m *MultiProgress) label(p *Progress) string {
+	size := 0
+	for _, e := range m.list {
+		size = max(size, width(e.name))
+	}
+	return p.name + strings.Repeat(" ", size-width(p.name)) + " " + p.line()
+}
+
+// Fprint writes the current state of all bars to w, one line per bar.
+func (m *MultiProgress) Fprint(w io.Writer) error {
+	_, err := io.WriteString(w, m.String())
+	return err
+}
+
+// String returns the current state of all bars as written by Fprint.
+func (m *MultiProgress) String() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	buf := strings.Builder{}
+	for _, e := range m.list {
+		buf.WriteString(m.label(e) + "\n")
+	}
+	return buf.String()
+}
+
+// NewMultiProgress creates a new MultiProgress instance without bars.
+func NewMultiProgress() *MultiProgress {
+	s, err := os.Stdout.Stat()
+	if err != nil {
+		log.Panicln("pretty: cannot stat stdout:", err)
+	}
+	return &MultiProgress{
+		Interval: time.Second,
+		// Identify if we are displaying to a terminal or through a pipe or redirect.
+		chardev: s.Mode()&os.ModeCharDevice == os.ModeCharDevice,
+		mutex:   &sync.Mutex{},
+	}
+}
+
+// Spinner is an animation for tasks that give no measure of progress at all, shown in front of a text.
+type Spinner struct {
+	// Logger is used by Print. If it is nil, the standard logger is used with the "pretty:" prefix.
+	Logger  *log.Logger
+	chardev bool
+	drawn   bool
+	frame   int
+	stamp   time.Time
+	text    string
+}
+
+// Print advances the animation and shows the text next to it. The spinner is redrawn at most 10 times per second on a
+// terminal, and once per second otherwise, so Print can be called as often as needed.
+func (s *Spinner) Print(text string) {
+	rate := time.Second
+	if s.chardev {
+		rate = time.Millisecond * 100
+	}
+	s.text = text
+	if s.drawn && time.Since(s.stamp) < rate {
+		return
+	}
+	if s.chardev && !s.drawn {
+		// Save cursor position.
+		escape(s.Logger, []byte{0x1b, 0x37})
+	}
+	if s.chardev && s.drawn {
+		// Load cursor position and erase the line, the text may have become shorter.
+		escape(s.Logger, []byte{0x1b, 0x38, 0x1b, '[', 'K'})
+	}
+	s.drawn = true
+	s.stamp = time.Now()
+	output(s.Logger, s.line())
+	s.frame++
+}
+
+// Function line renders the spinner.
+func (s *Spinner) line() string {
+	return string(`|/-\`[s.frame%4]) + " " + s.text
+}
+
+// Fprint writes the current state of the spinner and its last text to w as a single line.
+func (s *Spinner) Fprint(w io.Writer) error {
+	_, err := io.WriteString(w, s.String())
+	return err
+}
+
+// String returns the current state of the spinner as written by Fprint.
+func (s *Spinner) String() string {
+	return s.line() + "\n"
+}
+
+// NewSpinner creates a new Spinner instance.
+func NewSpinner() *Spinner {
+	s, err := os.Stdout.Stat()
+	if err != nil {
+		log.Panicln("pretty: cannot stat stdout:", err)
+	}
+	return &Spinner{
+		// Identify if we are displaying to a terminal or through a pipe or redirect.
+		chardev: s.Mode()&os.ModeCharDevice == os.ModeCharDevice,
+	}
+}
+
 // ProgressWriter is an io.Writer that updates a progress bar as data is written.
+//
+// The text shown next to the bar is produced by Template, a text/template with these fields:
+//
+//	.Bar      the bar and the percentage
+//	.Current  the amount done so far, e.g. "3.5 MiB"
+//	.Total    the total amount, e.g. "10.0 MiB"
+//	.Rate     the smoothed rate, e.g. "12.3 MiB/s"
+//	.Elapsed  the time since the writer was created, e.g. "01:05"
+//	.ETA      the estimated time left, or "--:--" if it is not known yet
+//
+// Amounts are bytes formatted with binary prefixes. For other tasks set Unit, e.g. to "items", and report progress
+// with Add instead of Write.
+//
+// A total of 0 means that the size of the task is unknown. The bar is replaced by an animation until SetTotal tells the
+// size. The total may change at any time, the bar is moved back if needed.
 type ProgressWriter struct {
-	p *Progress
-	m uint64
-	n uint64
+	// Logger is used to draw the bar. If it is nil, the standard logger is used with the "pretty:" prefix.
+	Logger *log.Logger
+	// Template formats the progress line, see ProgressWriterTemplate for the default. If it is invalid, Write fails and
+	// the bar is shown alone.
+	Template string
+	// Unit is the unit of the amounts. An empty unit means bytes.
+	Unit string
+	p    *Progress
+	m    uint64
+	n    uint64
+	// Time of creation, and the last rate sample with the amount done at that time.
+	since time.Time
+	stamp time.Time
+	mark  uint64
+	// Smoothed rate in units per second.
+	rate float64
+	// The parsed template and the string it was parsed from, or the error of parsing it.
+	tmpl *template.Template
+	text string
+	terr error
+}
+
+// ProgressWriterTemplate is the default template of a ProgressWriter.
+const ProgressWriterTemplate = "{{.Bar}} {{.Current}}/{{.Total}} {{.Rate}} {{.Elapsed}} ETA {{.ETA}}"
+
+// Add reports n more units done and updates the progress bar.
+func (p *ProgressWriter) Add(n uint64) {
+	p.m += n
+	now := time.Now()
+	if d := now.Sub(p.stamp); d >= time.Millisecond*100 {
+		// Exponential moving average of the rate, sampled at most every 100ms.
+		r := float64(p.m-p.mark) / d.Seconds()
+		if p.mark == 0 {
+			p.rate = r
+		} else {
+			p.rate = p.rate*0.7 + r*0.3
+		}
+		p.stamp = now
+		p.mark = p.m
+	}
+	p.show()
+}
+
+// SetTotal changes the total amount of the task, 0 means unknown, and updates the progress bar.
+func (p *ProgressWriter) SetTotal(n uint64) {
+	p.n = n
+	p.show()
 }
 
-// Write writes data to the ProgressWriter and updates the progress bar.
+// Function show updates the progress bar.
+func (p *ProgressWriter) show() {
+	p.p.Logger = p.Logger
+	if p.n == 0 {
+		p.p.Pulse()
+		return
+	}
+	p.p.Print(float64(p.m) / float64(p.n))
+}
+
+// Fprint writes the current state of the progress bar to w as a single line.
+func (p *ProgressWriter) Fprint(w io.Writer) error {
+	return p.p.Fprint(w)
+}
+
+// String returns the current state of the progress bar as written by Fprint.
+func (p *ProgressWriter) String() string {
+	return p.p.String()
+}
+
+// Write writes data to the ProgressWriter and updates the progress bar. It fails without writing anything if Template
+// is invalid.
 func (p *ProgressWriter) Write(b []byte) (int, error) {
+	if err := p.parse(); err != nil {
+		return 0, err
+	}
 	l := len(b)
-	p.m += uint64(l)
-	p.p.Print(float64(p.m) / float64(p.n))
+	p.Add(uint64(l))
 	return l, nil
 }
 
-// NewProgressWriter creates a new ProgressWriter for a task of the given size.
+// Function parse parses Template if it has changed, and returns the error of parsing it.
+func (p *ProgressWriter) parse() error {
+	if p.text == p.Template && (p.tmpl != nil || p.terr != nil) {
+		return p.terr
+	}
+	p.tmpl, p.terr = template.New("pretty").Parse(p.Template)
+	if p.terr != nil {
+		p.tmpl = nil
+		p.terr = fmt.Errorf("pretty: invalid template: %w", p.terr)
+	}
+	p.text = p.Template
+	return p.terr
+}
+
+// Function info renders the template around the bar. If the template is invalid, the bar is shown alone.
+func (p *ProgressWriter) info(bar string) string {
+	if p.parse() != nil {
+		return bar
+	}
+	elapsed := time.Since(p.since)
+	eta := "--:--"
+	total := "?"
+	if p.n != 0 {
+		total = p.size(float64(p.n))
+	}
+	if p.rate > 0 && p.n != 0 {
+		eta = clock(time.Duration(float64(p.n-min(p.m, p.n)) / p.rate * float64(time.Second)))
+	}
+	buf := strings.Builder{}
+	err := p.tmpl.Execute(&buf, map[string]string{
+		"Bar":     bar,
+		"Current": p.size(float64(p.m)),
+		"Total":   total,
+		"Rate":    p.size(p.rate) + "/s",
+		"Elapsed": clock(elapsed),
+		"ETA":     eta,
+	})
+	if err != nil {
+		return bar
+	}
+	return buf.String()
+}
+
+// Function size formats an amount in the unit of the writer.
+func (p *ProgressWriter) size(n float64) string {
+	if p.Unit != "" {
+		if n == float64(uint64(n)) {
+			return fmt.Sprintf("%d %s", uint64(n), p.Unit)
+		}
+		return fmt.Sprintf("%.1f %s", n, p.Unit)
+	}
+	if n < 1024 {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	unit := "KMGTPE"
+	i := 0
+	for n /= 1024; n >= 1024 && i < len(unit)-1; i++ {
+		n /= 1024
+	}
+	return fmt.Sprintf("%.1f %ciB", n, unit[i])
+}
+
+// Function clock formats a duration as mm:ss, or h:mm:ss if it is longer than an hour.
+func clock(d time.Duration) string {
+	s := int(d.Round(time.Second) / time.Second)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%02d:%02d", s/60, s%60)
+}
+
+// NewProgressWriter creates a new ProgressWriter for a task of the given size, or of unknown size if n is 0.
 //
 // For example, to display progress while reading from a reader:
 //
@@ -91,68 +557,198 @@ func (p *ProgressWriter) Write(b []byte) (int, error) {
 //
 //	writer := io.MultiWriter(os.Stdout, NewProgressWriter(1024))
 func NewProgressWriter(n uint64) *ProgressWriter {
-	p := NewProgress()
-	p.Print(0)
-	return &ProgressWriter{
-		p: p,
-		m: 0,
-		n: n,
+	p := &ProgressWriter{
+		Template: ProgressWriterTemplate,
+		p:        NewProgress(),
+		m:        0,
+		n:        n,
+		since:    time.Now(),
+		stamp:    time.Now(),
 	}
+	p.p.info = p.info
+	p.show()
+	return p
 }
 
-// Table represents a table structure with a head and body.
+// TableStyle selects how the borders of a Table are drawn.
+type TableStyle int
+
+const (
+	// TableStylePlain separates the head from the body with a line of dashes.
+	TableStylePlain TableStyle = iota
+	// TableStyleASCII draws a grid with +, - and | characters.
+	TableStyleASCII
+	// TableStyleBox draws a grid with Unicode box drawing characters.
+	TableStyleBox
+	// TableStyleRounded is like TableStyleBox, with rounded corners.
+	TableStyleRounded
+	// TableStyleCompact draws no borders at all.
+	TableStyleCompact
+)
+
+// A tableBorder lists the characters of a style. A rule is drawn from its left end, fill, crossing and right end, a row
+// from its left border, column separator and right border. Rules that are not drawn are empty.
+type tableBorder struct {
+	top [4]string
+	mid [4]string
+	bot [4]string
+	row [3]string
+	// Default padding on each side of a cell.
+	pad int
+}
+
+var tableBorders = map[TableStyle]tableBorder{
+	TableStylePlain: {
+		mid: [4]string{"", "-", "-", ""},
+		row: [3]string{"", " ", ""},
+	},
+	TableStyleASCII: {
+		top: [4]string{"+", "-", "+", "+"},
+		mid: [4]string{"+", "-", "+", "+"},
+		bot: [4]string{"+", "-", "+", "+"},
+		row: [3]string{"|", "|", "|"},
+		pad: 1,
+	},
+	TableStyleBox: {
+		top: [4]string{"┌", "─", "┬", "┐"},
+		mid: [4]string{"├", "─", "┼", "┤"},
+		bot: [4]string{"└", "─", "┴", "┘"},
+		row: [3]string{"│", "│", "│"},
+		pad: 1,
+	},
+	TableStyleRounded: {
+		top: [4]string{"╭", "─", "┬", "╮"},
+		mid: [4]string{"├", "─", "┼", "┤"},
+		bot: [4]string{"╰", "─", "┴", "╯"},
+		row: [3]string{"│", "│", "│"},
+		pad: 1,
+	},
+	TableStyleCompact: {
+		row: [3]string{"", " ", ""},
+	},
+}
+
+// Table represents a table structure with a head and body. Cells are measured by their display width in a terminal, so
+// that wide CJK characters, emoji, combining marks and colored text stay aligned.
 type Table struct {
-	// Conf specifies the alignment for each column: "<" for left, ">" for right.
+	// Conf specifies the alignment for each column: "<" for left, ">" for right, "^" for center.
 	// If conf has fewer entries than head, the remaining columns default to left alignment ("<").
 	Conf []string
 	// Head represents the head of the table.
 	Head []string
 	// Body represents the body of the table.
 	Body [][]string
+	// Pad specifies the number of spaces on each side of the cells of each column. If pad has fewer entries than head,
+	// the remaining columns use the default of the style: 0 for plain and compact, 1 for the others. Negative values are
+	// invalid.
+	Pad []int
+	// Style selects the borders, the default is TableStylePlain.
+	Style TableStyle
+	// Logger is used by Print. If it is nil, the standard logger is used with the "pretty:" prefix.
+	Logger *log.Logger
 }
 
-// Print prints the table to the console with proper alignment.
+// Print prints the table to the console with proper alignment. It panics if the table is invalid.
 func (t *Table) Print() {
-	conf := slices.Clone(t.Conf)
-	for range len(t.Head) - len(conf) {
-		conf = append(conf, "<")
+	lines, err := t.lines()
+	if err != nil {
+		log.Panicln(err)
 	}
-	size := make([]int, len(t.Head))
-	for i, c := range t.Head {
-		size[i] = len(c)
+	for _, e := range lines {
+		output(t.Logger, e)
 	}
-	for _, r := range t.Body {
-		for i, c := range r {
-			size[i] = max(size[i], len(c))
-		}
+}
+
+// Fprint writes the table to w with proper alignment. An error is returned if an alignment in Conf, a padding in Pad
+// or the style is invalid.
+func (t *Table) Fprint(w io.Writer) error {
+	lines, err := t.lines()
+	if err != nil {
+		return err
 	}
-	line := make([]string, len(t.Head))
-	for i, c := range t.Head {
-		l := size[i]
-		switch conf[i] {
-		case "<":
-			line[i] = c + strings.Repeat(" ", l-len(c))
-		case ">":
-			line[i] = strings.Repeat(" ", l-len(c)) + c
+	_, err = io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// String returns the table as written by Fprint. It panics if the table is invalid.
+func (t *Table) String() string {
+	buf := strings.Builder{}
+	if err := t.Fprint(&buf); err != nil {
+		log.Panicln(err)
+	}
+	return buf.String()
+}
+
+// Function lines renders the table.
+func (t *Table) lines() ([]string, error) {
+	border, ok := tableBorders[t.Style]
+	if !ok {
+		return nil, fmt.Errorf("pretty: invalid table style %d", t.Style)
+	}
+	conf, err := t.conf()
+	if err != nil {
+		return nil, err
+	}
+	pad := slices.Clone(t.Pad)
+	for range len(t.Head) - len(pad) {
+		pad = append(pad, border.pad)
+	}
+	for i, n := range pad {
+		if n < 0 {
+			return nil, fmt.Errorf("pretty: invalid padding %d for column %d", n, i)
 		}
 	}
-	log.Println("pretty:", strings.Join(line, " "))
-	for i, n := range size {
-		line[i] = strings.Repeat("-", n)
+	size := make([]int, len(t.Head))
+	for i, c := range t.Head {
+		size[i] = width(c)
 	}
-	log.Println("pretty:", strings.Join(line, "-"))
 	for _, r := range t.Body {
-		for i, c := range r {
-			l := size[i]
+		for i, c := range r[:min(len(r), len(size))] {
+			size[i] = max(size[i], width(c))
+		}
+	}
+	row := func(cell []string) string {
+		line := make([]string, len(t.Head))
+		for i := range t.Head {
+			c := ""
+			if i < len(cell) {
+				c = cell[i]
+			}
+			l := size[i] - width(c)
 			switch conf[i] {
 			case "<":
-				line[i] = c + strings.Repeat(" ", l-len(c))
+				c = c + strings.Repeat(" ", l)
 			case ">":
-				line[i] = strings.Repeat(" ", l-len(c)) + c
+				c = strings.Repeat(" ", l) + c
+			case "^":
+				c = strings.Repeat(" ", l/2) + c + strings.Repeat(" ", l-l/2)
 			}
+			line[i] = strings.Repeat(" ", pad[i]) + c + strings.Repeat(" ", pad[i])
+		}
+		return border.row[0] + strings.Join(line, border.row[1]) + border.row[2]
+	}
+	rule := func(r [4]string) string {
+		line := make([]string, len(t.Head))
+		for i, n := range size {
+			line[i] = strings.Repeat(r[1], n+pad[i]*2)
 		}
-		log.Println("pretty:", strings.Join(line, " "))
+		return r[0] + strings.Join(line, r[2]) + r[3]
+	}
+	r := []string{}
+	if border.top[1] != "" {
+		r = append(r, rule(border.top))
+	}
+	r = append(r, row(t.Head))
+	if border.mid[1] != "" {
+		r = append(r, rule(border.mid))
+	}
+	for _, e := range t.Body {
+		r = append(r, row(e))
 	}
+	if border.bot[1] != "" {
+		r = append(r, rule(border.bot))
+	}
+	return r, nil
 }
 
 // NewTable creates a new Table instance.
@@ -164,30 +760,49 @@ func NewTable() *Table {
 type Tree struct {
 	Name string
 	Leaf []*Tree
+	// Logger is used by Print. If it is nil, the standard logger is used with the "pretty:" prefix. The Logger of
+	// the leaves is ignored.
+	Logger *log.Logger
 }
 
-func (t *Tree) print(prefix string) {
+func (t *Tree) print(prefix string, emit func(string)) {
 	for i, elem := range t.Leaf {
 		isLast := i == len(t.Leaf)-1
 		branch := "├── "
 		if isLast {
 			branch = "└── "
 		}
-		log.Println("pretty:", prefix+branch+elem.Name)
+		emit(prefix + branch + elem.Name)
 		if len(elem.Leaf) > 0 {
 			middle := "│   "
 			if isLast {
 				middle = "    "
 			}
-			elem.print(prefix + middle)
+			elem.print(prefix+middle, emit)
 		}
 	}
 }
 
 // Print prints the tree structure starting from the root node.
 func (t *Tree) Print() {
-	log.Println("pretty:", t.Name)
-	t.print("")
+	output(t.Logger, t.Name)
+	t.print("", func(line string) { output(t.Logger, line) })
+}
+
+// Fprint writes the tree structure starting from the root node to w.
+func (t *Tree) Fprint(w io.Writer) error {
+	_, err := io.WriteString(w, t.String())
+	return err
+}
+
+// String returns the tree structure as written by Fprint.
+func (t *Tree) String() string {
+	buf := strings.Builder{}
+	buf.WriteString(t.Name + "\n")
+	t.print("", func(line string) {
+		buf.WriteString(line + "\n")
+	})
+	return buf.String()
 }
 
 // NewTree creates a new Tree node with the given name.
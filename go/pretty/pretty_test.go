@@ -0,0 +1,495 @@
+package pretty
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWidth(t *testing.T) {
+	for _, e := range []struct {
+		s string
+		n int
+	}{
+		{"", 0},
+		{"hello", 5},
+		{"中文", 4},
+		{"ｈｅｌｌｏ", 10},
+		{"한국어", 6},
+		{"日本語のテキスト", 16},
+		{"café", 4},
+		{"café", 4},
+		{"é̂", 1},
+		{"🎉", 2},
+		{"👍🏽", 2},
+		{"👨‍👩‍👧‍👦", 2},
+		{"❤️", 2},
+		{"1️⃣", 2},
+		{"🇯🇵🇫🇷", 4},
+		{"\x1b[31mred\x1b[0m", 3},
+		{"\x1b[1;38;5;208m中\x1b[m", 2},
+		{"a\tb", 2},
+	} {
+		if width(e.s) != e.n {
+			t.Errorf("width(%q) = %d, want %d", e.s, width(e.s), e.n)
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	out := bytes.Buffer{}
+	p := &Progress{Logger: log.New(&out, "", 0)}
+	bar := func(n int) string {
+		return "[" + strings.Repeat("=", n) + ">" + strings.Repeat(" ", 44-n) + "]"
+	}
+	p.Pulse()
+	if p.String() != "[<=>"+strings.Repeat(" ", 42)+"]   ?%\n" {
+		t.FailNow()
+	}
+	// The animation advances at most once per second when the output is not a terminal.
+	p.Pulse()
+	if p.frame != 0 {
+		t.FailNow()
+	}
+	p.stamp = time.Now().Add(-time.Second)
+	p.Pulse()
+	if p.String() != "[ <=>"+strings.Repeat(" ", 41)+"]   ?%\n" {
+		t.FailNow()
+	}
+	p.Print(0.5)
+	if p.String() != bar(22)+"  50%\n" {
+		t.FailNow()
+	}
+	p.Print(2)
+	if p.String() != bar(44)+" 100%\n" {
+		t.FailNow()
+	}
+	p.Print(0.3)
+	if p.String() != bar(13)+"  30%\n" {
+		t.FailNow()
+	}
+	// A small step back is drawn too.
+	p.Print(0.295)
+	if p.current != 0.295 {
+		t.FailNow()
+	}
+	p.Print(0.3)
+	if p.current != 0.295 {
+		t.FailNow()
+	}
+	p.Print(-1)
+	if p.String() != bar(0)+"   0%\n" {
+		t.FailNow()
+	}
+	want := strings.Join([]string{
+		"[<=>" + strings.Repeat(" ", 42) + "]   ?%",
+		"[ <=>" + strings.Repeat(" ", 41) + "]   ?%",
+		bar(22) + "  50%",
+		bar(44) + " 100%",
+		bar(13) + "  30%",
+		bar(12) + "  29%",
+		bar(0) + "   0%",
+	}, "\n") + "\n"
+	if out.String() != want {
+		t.Fatal(out.String())
+	}
+	buf := bytes.Buffer{}
+	if err := p.Fprint(&buf); err != nil || buf.String() != bar(0)+"   0%\n" {
+		t.FailNow()
+	}
+}
+
+func TestSpinner(t *testing.T) {
+	out := bytes.Buffer{}
+	s := &Spinner{Logger: log.New(&out, "", 0)}
+	s.Print("a")
+	if s.String() != "/ a\n" {
+		t.FailNow()
+	}
+	// The text is kept, but the animation waits.
+	s.Print("b")
+	if s.String() != "/ b\n" {
+		t.FailNow()
+	}
+	s.stamp = time.Now().Add(-time.Second)
+	s.Print("c")
+	if s.String() != "- c\n" {
+		t.FailNow()
+	}
+	if out.String() != "| a\n/ c\n" {
+		t.FailNow()
+	}
+	buf := bytes.Buffer{}
+	if err := s.Fprint(&buf); err != nil || buf.String() != "- c\n" {
+		t.FailNow()
+	}
+}
+
+func TestTree(t *testing.T) {
+	out := bytes.Buffer{}
+	tree := NewTree(".")
+	tree.Logger = log.New(&out, "", 0)
+	a := NewTree("a")
+	a.Leaf = append(a.Leaf, NewTree("a1"))
+	tree.Leaf = append(tree.Leaf, a, NewTree("b"))
+	want := ".\n├── a\n│   └── a1\n└── b\n"
+	if tree.String() != want {
+		t.FailNow()
+	}
+	buf := bytes.Buffer{}
+	if err := tree.Fprint(&buf); err != nil || buf.String() != want {
+		t.FailNow()
+	}
+	tree.Print()
+	if out.String() != want {
+		t.FailNow()
+	}
+}
+
+func TestMultiProgress(t *testing.T) {
+	// Outputs are held back by the interval.
+	m := &MultiProgress{Interval: time.Hour, last: time.Now(), mutex: &sync.Mutex{}}
+	a := m.Add("a")
+	b := m.Add("bb")
+	done := make(chan struct{})
+	go func() {
+		for i := range 99 {
+			a.Print(float64(i) / 100)
+		}
+		close(done)
+	}()
+	m.Remove("a")
+	<-done
+	if m.Get("a") != nil || m.String() != "bb [>"+strings.Repeat(" ", 44)+"]   0%\n" {
+		t.FailNow()
+	}
+	b.Print(0.5)
+	if b.String() != "[======================>"+strings.Repeat(" ", 22)+"]  50%\n" {
+		t.FailNow()
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	w := &ProgressWriter{
+		Logger:   log.New(io.Discard, "", 0),
+		Template: "{{.Current}}/{{.Total}}",
+		p:        &Progress{},
+		n:        1000,
+		since:    time.Now(),
+	}
+	w.p.info = w.info
+	w.Add(500)
+	if w.String() != "500 B/1000 B\n" {
+		t.FailNow()
+	}
+	// The text is refreshed once per second, even if the bar does not move.
+	w.Add(1)
+	if w.p.current != 0.5 {
+		t.FailNow()
+	}
+	w.p.stamp = time.Now().Add(-time.Second)
+	w.Add(1)
+	if w.p.current != 0.502 {
+		t.FailNow()
+	}
+	w.Template = "{{.Current"
+	if n, err := w.Write([]byte{0}); n != 0 || err == nil {
+		t.FailNow()
+	}
+	if w.String() != "[======================>"+strings.Repeat(" ", 22)+"]  50%\n" {
+		t.FailNow()
+	}
+	w.Template = "{{.ETA}}"
+	if n, err := w.Write([]byte{0}); n != 1 || err != nil {
+		t.FailNow()
+	}
+	// A larger total moves the bar back, even below 1%.
+	w.Template = "{{.Bar}}"
+	w.SetTotal(1 << 20)
+	if w.String() != "[>"+strings.Repeat(" ", 44)+"]   0%\n" {
+		t.FailNow()
+	}
+}
+
+func TestProgressWriterSize(t *testing.T) {
+	for _, e := range []struct {
+		unit string
+		n    float64
+		want string
+	}{
+		{"", 0, "0 B"},
+		{"", 1023, "1023 B"},
+		{"", 1536, "1.5 KiB"},
+		{"", 3 << 30, "3.0 GiB"},
+		{"", 1 << 70, "1024.0 EiB"},
+		{"items", 3, "3 items"},
+		{"items", 2.5, "2.5 items"},
+	} {
+		w := &ProgressWriter{Unit: e.unit}
+		if s := w.size(e.n); s != e.want {
+			t.Fatal(s)
+		}
+	}
+	for _, e := range []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "00:00"},
+		{time.Millisecond * 1400, "00:01"},
+		{time.Second * 65, "01:05"},
+		{time.Second * 3661, "1:01:01"},
+	} {
+		if s := clock(e.d); s != e.want {
+			t.Fatal(s)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	table := NewTable()
+	table.Head = []string{"Name", "City", "Score"}
+	table.Conf = []string{"<", "<", ">"}
+	table.Body = [][]string{
+		{"Zoë", "Zürich", "1"},
+		{"李小龍", "香港", "100"},
+		{"田中", "東京", "42"},
+		{"\x1b[32mAda\x1b[0m", "London 🇬🇧", "7"},
+		{"José", "São Paulo", "3"},
+	}
+	want := "" +
+		"Name   City      Score\n" +
+		"----------------------\n" +
+		"Zoë    Zürich        1\n" +
+		"李小龍 香港        100\n" +
+		"田中   東京         42\n" +
+		"\x1b[32mAda\x1b[0m    London 🇬🇧     7\n" +
+		"José   São Paulo     3\n"
+	if table.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", table.String(), want)
+	}
+}
+
+func TestTableStyle(t *testing.T) {
+	table := NewTable()
+	table.Head = []string{"Name", "Qty", "Note"}
+	table.Conf = []string{"<", ">", "^"}
+	table.Body = [][]string{
+		{"apple", "3", "ok"},
+		{"梨", "12", "fresh"},
+	}
+	for _, e := range []struct {
+		style TableStyle
+		want  string
+	}{
+		{TableStylePlain, "" +
+			"Name  Qty Note \n" +
+			"---------------\n" +
+			"apple   3  ok  \n" +
+			"梨     12 fresh\n"},
+		{TableStyleASCII, "" +
+			"+-------+-----+-------+\n" +
+			"| Name  | Qty | Note  |\n" +
+			"+-------+-----+-------+\n" +
+			"| apple |   3 |  ok   |\n" +
+			"| 梨    |  12 | fresh |\n" +
+			"+-------+-----+-------+\n"},
+		{TableStyleBox, "" +
+			"┌───────┬─────┬───────┐\n" +
+			"│ Name  │ Qty │ Note  │\n" +
+			"├───────┼─────┼───────┤\n" +
+			"│ apple │   3 │  ok   │\n" +
+			"│ 梨    │  12 │ fresh │\n" +
+			"└───────┴─────┴───────┘\n"},
+		{TableStyleRounded, "" +
+			"╭───────┬─────┬───────╮\n" +
+			"│ Name  │ Qty │ Note  │\n" +
+			"├───────┼─────┼───────┤\n" +
+			"│ apple │   3 │  ok   │\n" +
+			"│ 梨    │  12 │ fresh │\n" +
+			"╰───────┴─────┴───────╯\n"},
+		{TableStyleCompact, "" +
+			"Name  Qty Note \n" +
+			"apple   3  ok  \n" +
+			"梨     12 fresh\n"},
+	} {
+		table.Style = e.style
+		if table.String() != e.want {
+			t.Errorf("style %d: got\n%swant\n%s", e.style, table.String(), e.want)
+		}
+	}
+	table.Style = TableStylePlain
+	table.Pad = []int{0, 2}
+	want := "" +
+		"Name    Qty   Note \n" +
+		"-------------------\n" +
+		"apple     3    ok  \n" +
+		"梨       12   fresh\n"
+	if table.String() != want {
+		t.Errorf("pad: got\n%swant\n%s", table.String(), want)
+	}
+	table.Pad = []int{0, -1}
+	if err := table.Fprint(io.Discard); err == nil {
+		t.FailNow()
+	}
+	table.Pad = nil
+	table.Conf = []string{"<", "right"}
+	if err := table.Fprint(io.Discard); err == nil {
+		t.FailNow()
+	}
+}
+
+func TestTableEncode(t *testing.T) {
+	table := NewTable()
+	table.Head = []string{"Name", "Note", "Qty"}
+	table.Conf = []string{"<", "^", ">"}
+	table.Body = [][]string{
+		{"a,b", `say "hi"`, "1"},
+		{"\x1b[31m<red>\x1b[0m", "x|y\nz", "2"},
+	}
+	for _, e := range []struct {
+		f    func(w io.Writer) error
+		want string
+	}{
+		{table.CSV, "Name,Note,Qty\n\"a,b\",\"say \"\"hi\"\"\",1\n<red>,\"x|y\nz\",2\n"},
+		{table.TSV, "Name\tNote\tQty\na,b\t\"say \"\"hi\"\"\"\t1\n<red>\t\"x|y\nz\"\t2\n"},
+		{table.Markdown, "" +
+			"| Name | Note | Qty |\n" +
+			"| :--- | :---: | ---: |\n" +
+			"| a,b | say \"hi\" | 1 |\n" +
+			"| &lt;red&gt; | x\\|y<br>z | 2 |\n"},
+		{table.HTML, "" +
+			"<table>\n<thead>\n" +
+			"<tr><th>Name</th><th style=\"text-align: center\">Note</th><th style=\"text-align: right\">Qty</th></tr>\n" +
+			"</thead>\n<tbody>\n" +
+			"<tr><td>a,b</td><td style=\"text-align: center\">say &#34;hi&#34;</td><td style=\"text-align: right\">1</td></tr>\n" +
+			"<tr><td>&lt;red&gt;</td><td style=\"text-align: center\">x|y\nz</td><td style=\"text-align: right\">2</td></tr>\n" +
+			"</tbody>\n</table>\n"},
+		{table.JSON, `[{"Name":"a,b","Note":"say \"hi\"","Qty":"1"},{"Name":"<red>","Note":"x|y\nz","Qty":"2"}]` + "\n"},
+	} {
+		buf := strings.Builder{}
+		if err := e.f(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != e.want {
+			t.Errorf("got\n%s\nwant\n%s", buf.String(), e.want)
+		}
+	}
+	table.Body = [][]string{{"a&amp;b"}}
+	if buf := (strings.Builder{}); table.Markdown(&buf) != nil || !strings.Contains(buf.String(), "| a&amp;amp;b |") {
+		t.FailNow()
+	}
+	table.Conf = []string{"left"}
+	if table.Markdown(io.Discard) == nil || table.HTML(io.Discard) == nil {
+		t.FailNow()
+	}
+	// Keys of JSON objects must be unique, once colors are removed.
+	table.Head = []string{"Name", "\x1b[1mName\x1b[0m"}
+	if table.JSON(io.Discard) == nil {
+		t.FailNow()
+	}
+}
+
+func TestTableOf(t *testing.T) {
+	type Base struct {
+		ID int
+	}
+	type Task struct {
+		Base
+		Name    string
+		Cost    float64 `pretty:"Cost,format=%.2f"`
+		Done    bool    `pretty:",align=^"`
+		Took    time.Duration
+		When    time.Time
+		Secret  string `pretty:"-"`
+		Hidden  string `pretty:",omit"`
+		Owner   *string
+		private int
+	}
+	owner := "ann"
+	when := time.Date(2025, 9, 9, 10, 34, 44, 0, time.UTC)
+	table, err := TableOf([]*Task{
+		{Base{1}, "build", 1.5, true, time.Second * 90, when, "x", "y", &owner, 0},
+		{Base{20}, "test", 12, false, time.Millisecond * 1500, time.Time{}, "x", "y", nil, 0},
+		nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(table.Head, []string{"ID", "Name", "Cost", "Done", "Took", "When", "Owner"}) {
+		t.Fatal(table.Head)
+	}
+	if !slices.Equal(table.Conf, []string{">", "<", ">", "^", ">", "<", "<"}) {
+		t.Fatal(table.Conf)
+	}
+	if !slices.Equal(table.Body[0], []string{"1", "build", "1.50", "true", "1m30s", "2025-09-09 10:34:44", "ann"}) {
+		t.Fatal(table.Body[0])
+	}
+	if !slices.Equal(table.Body[1], []string{"20", "test", "12.00", "false", "1.5s", "", ""}) {
+		t.Fatal(table.Body[1])
+	}
+	if !slices.Equal(table.Body[2], make([]string, 7)) {
+		t.Fatal(table.Body[2])
+	}
+	table, err = TableOf([]map[string]any{
+		{"name": "a", "size": 10},
+		{"name": "b", "size": 2.5, "tag": nil},
+		{"size": nil, "tag": "x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(table.Head, []string{"name", "size", "tag"}) || !slices.Equal(table.Conf, []string{"<", ">", "<"}) {
+		t.Fatal(table.Head, table.Conf)
+	}
+	if !slices.Equal(table.Body[1], []string{"b", "2.5", ""}) || !slices.Equal(table.Body[2], []string{"", "", "x"}) {
+		t.Fatal(table.Body)
+	}
+	type Meta struct {
+		Note string
+	}
+	type Stamp struct {
+		time.Time
+		*Base `pretty:"-"`
+		Meta  `pretty:"Meta"`
+		Name  string
+	}
+	table, err = TableOf([]Stamp{{when, &Base{1}, Meta{"n"}, "a"}, {Name: "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(table.Head, []string{"Time", "Meta", "Name"}) {
+		t.Fatal(table.Head)
+	}
+	if !slices.Equal(table.Body[0], []string{"2025-09-09 10:34:44", "{n}", "a"}) {
+		t.Fatal(table.Body[0])
+	}
+	if !slices.Equal(table.Body[1], []string{"", "{}", "b"}) {
+		t.Fatal(table.Body[1])
+	}
+	table, err = TableOf([]struct {
+		Base `pretty:",omit"`
+		Name string
+	}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(table.Head, []string{"Name"}) {
+		t.Fatal(table.Head)
+	}
+	if _, err := TableOf(1); err == nil {
+		t.FailNow()
+	}
+	if _, err := TableOf([]int{1}); err == nil {
+		t.FailNow()
+	}
+	if _, err := TableOf([]struct {
+		A int `pretty:",align=right"`
+	}{}); err == nil {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,245 @@
+package pretty
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A column describes how a struct field or a map key is turned into a column of a Table.
+type column struct {
+	name   string
+	align  string
+	format string
+	// Index of the struct field, or the key in a map.
+	index []int
+	key   reflect.Value
+}
+
+// Function numeric reports whether values of type t are numbers, which are right aligned by default.
+func numeric(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// Function cell formats a value with the format of column c, or with a default format for its type.
+func cell(c *column, v reflect.Value) string {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return ""
+	}
+	if c.format != "" {
+		return fmt.Sprintf(c.format, v.Interface())
+	}
+	switch e := v.Interface().(type) {
+	case time.Time:
+		if e.IsZero() {
+			return ""
+		}
+		return e.Format(time.DateTime)
+	case time.Duration:
+		return e.String()
+	}
+	switch v.Kind() {
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32)
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// Function promoted reports whether the fields of an embedded struct of type t are promoted, which is the case if it
+// has any exported field. Other structs, such as time.Time, are a single column.
+func promoted(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	return slices.ContainsFunc(reflect.VisibleFields(t), func(f reflect.StructField) bool {
+		return f.IsExported()
+	})
+}
+
+// Function fields returns the columns of a struct type, following the pretty tags of its fields.
+func fields(t reflect.Type) ([]*column, error) {
+	r := []*column{}
+	// Index of the embedded fields whose own fields are left out.
+	skip := [][]int{}
+	for _, f := range reflect.VisibleFields(t) {
+		if slices.ContainsFunc(skip, func(e []int) bool {
+			return len(e) < len(f.Index) && slices.Equal(e, f.Index[:len(e)])
+		}) || !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		c := &column{name: f.Name, index: f.Index}
+		if numeric(f.Type) {
+			c.align = ">"
+		}
+		tag := f.Tag.Get("pretty")
+		if tag == "-" {
+			skip = append(skip, f.Index)
+			continue
+		}
+		omit := false
+		for i, e := range strings.Split(tag, ",") {
+			k, v, _ := strings.Cut(e, "=")
+			switch {
+			case i == 0:
+				if e != "" {
+					c.name = e
+				}
+			case k == "align" && (v == "<" || v == ">" || v == "^"):
+				c.align = v
+			case k == "format" && v != "":
+				c.format = v
+			case e == "omit":
+				omit = true
+			default:
+				return nil, fmt.Errorf("pretty: invalid tag option %q of field %s", e, f.Name)
+			}
+		}
+		named := tag != "" && !strings.HasPrefix(tag, ",")
+		switch {
+		case f.Anonymous && !omit && !named && promoted(f.Type):
+			// Fields of embedded structs are promoted, and visited on their own.
+		case f.Anonymous:
+			// An embedded field that is left out, or that is a column itself, hides its fields.
+			skip = append(skip, f.Index)
+			if !omit && f.IsExported() {
+				r = append(r, c)
+			}
+		case !omit:
+			r = append(r, c)
+		}
+	}
+	return r, nil
+}
+
+// TableOf builds a table from a slice of structs, of pointers to structs, or of maps with string keys.
+//
+// For structs, each exported field is a column, and fields of embedded structs are promoted. The column is configured
+// by the pretty tag of the field, a comma separated list whose first element is the name of the column, the field name
+// by default, followed by options:
+//
+//	align=<    align the column to the left, > to the right, ^ to the center
+//	format=%x  format the values with fmt.Sprintf
+//	omit       leave the field out, a tag of "-" does the same
+//
+// Like in encoding/json, an embedded struct that is left out takes its fields with it, and an embedded struct named by
+// its tag is a single column. So is an embedded struct without exported fields, such as time.Time.
+//
+// For maps, each key is a column, in sorted order. A column is right aligned if all its values are numbers.
+//
+// Numbers are right aligned by default. Times are formatted as "2006-01-02 15:04:05", durations like "1m30s", and nil
+// pointers as empty cells.
+func TableOf(slice any) (*Table, error) {
+	v := reflect.ValueOf(slice)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("pretty: table of %T, want a slice", slice)
+	}
+	t := v.Type().Elem()
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	cols := []*column{}
+	switch {
+	case t.Kind() == reflect.Struct:
+		l, err := fields(t)
+		if err != nil {
+			return nil, err
+		}
+		cols = l
+	case t.Kind() == reflect.Map && t.Key().Kind() == reflect.String:
+		cols = keys(v)
+	default:
+		return nil, fmt.Errorf("pretty: table of %T, want a slice of structs or maps", slice)
+	}
+	table := NewTable()
+	for _, c := range cols {
+		table.Head = append(table.Head, c.name)
+		table.Conf = append(table.Conf, cmp.Or(c.align, "<"))
+	}
+	for i := range v.Len() {
+		e := v.Index(i)
+		for e.Kind() == reflect.Pointer && !e.IsNil() {
+			e = e.Elem()
+		}
+		row := make([]string, len(cols))
+		for j, c := range cols {
+			switch {
+			case e.Kind() == reflect.Pointer:
+				// A nil element is an empty row.
+			case c.key.IsValid():
+				row[j] = cell(c, e.MapIndex(c.key))
+			default:
+				if f, err := e.FieldByIndexErr(c.index); err == nil {
+					row[j] = cell(c, f)
+				}
+			}
+		}
+		table.Body = append(table.Body, row)
+	}
+	return table, nil
+}
+
+// Function keys returns the columns of a slice of maps, one for each key found in any of the maps.
+func keys(v reflect.Value) []*column {
+	m := map[string]*column{}
+	// Whether all values of a column seen so far are numbers.
+	n := map[string]bool{}
+	for i := range v.Len() {
+		e := v.Index(i)
+		for e.Kind() == reflect.Pointer && !e.IsNil() {
+			e = e.Elem()
+		}
+		if e.Kind() != reflect.Map {
+			continue
+		}
+		for iter := e.MapRange(); iter.Next(); {
+			k := iter.Key().String()
+			if m[k] == nil {
+				m[k] = &column{name: k, key: iter.Key()}
+				n[k] = true
+			}
+			x := iter.Value()
+			for x.Kind() == reflect.Interface || x.Kind() == reflect.Pointer {
+				if x.IsNil() {
+					break
+				}
+				x = x.Elem()
+			}
+			if x.Kind() != reflect.Interface && x.Kind() != reflect.Pointer && !numeric(x.Type()) {
+				n[k] = false
+			}
+		}
+	}
+	r := []*column{}
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		if n[k] {
+			m[k].align = ">"
+		}
+		r = append(r, m[k])
+	}
+	return r
+}
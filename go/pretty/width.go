@@ -0,0 +1,104 @@
+package pretty
+
+import (
+	"slices"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Ranges of code points that are displayed in two columns: East Asian Wide and Fullwidth characters, and emoji with
+// default emoji presentation. Sorted, inclusive.
+var wideTable = [][2]rune{
+	{0x1100, 0x115f}, {0x231a, 0x231b}, {0x2329, 0x232a}, {0x23e9, 0x23ec}, {0x23f0, 0x23f0}, {0x23f3, 0x23f3},
+	{0x25fd, 0x25fe}, {0x2614, 0x2615}, {0x2648, 0x2653}, {0x267f, 0x267f}, {0x2693, 0x2693}, {0x26a1, 0x26a1},
+	{0x26aa, 0x26ab}, {0x26bd, 0x26be}, {0x26c4, 0x26c5}, {0x26ce, 0x26ce}, {0x26d4, 0x26d4}, {0x26ea, 0x26ea},
+	{0x26f2, 0x26f3}, {0x26f5, 0x26f5}, {0x26fa, 0x26fa}, {0x26fd, 0x26fd}, {0x2705, 0x2705}, {0x270a, 0x270b},
+	{0x2728, 0x2728}, {0x274c, 0x274c}, {0x274e, 0x274e}, {0x2753, 0x2755}, {0x2757, 0x2757}, {0x2795, 0x2797},
+	{0x27b0, 0x27b0}, {0x27bf, 0x27bf}, {0x2b1b, 0x2b1c}, {0x2b50, 0x2b50}, {0x2b55, 0x2b55}, {0x2e80, 0x303e},
+	{0x3041, 0x33ff}, {0x3400, 0x4dbf}, {0x4e00, 0x9fff}, {0xa000, 0xa4cf}, {0xa960, 0xa97f}, {0xac00, 0xd7a3},
+	{0xf900, 0xfaff}, {0xfe10, 0xfe19}, {0xfe30, 0xfe6f}, {0xff00, 0xff60}, {0xffe0, 0xffe6}, {0x16fe0, 0x16fe4},
+	{0x17000, 0x18cff}, {0x1b000, 0x1b2ff}, {0x1f004, 0x1f004}, {0x1f0cf, 0x1f0cf}, {0x1f18e, 0x1f18e},
+	{0x1f191, 0x1f19a}, {0x1f200, 0x1f202}, {0x1f210, 0x1f23b}, {0x1f240, 0x1f248}, {0x1f250, 0x1f251},
+	{0x1f260, 0x1f265}, {0x1f300, 0x1f320}, {0x1f32d, 0x1f335}, {0x1f337, 0x1f37c}, {0x1f37e, 0x1f393},
+	{0x1f3a0, 0x1f3ca}, {0x1f3cf, 0x1f3d3}, {0x1f3e0, 0x1f3f0}, {0x1f3f4, 0x1f3f4}, {0x1f3f8, 0x1f43e},
+	{0x1f440, 0x1f440}, {0x1f442, 0x1f4fc}, {0x1f4ff, 0x1f53d}, {0x1f54b, 0x1f54e}, {0x1f550, 0x1f567},
+	{0x1f57a, 0x1f57a}, {0x1f595, 0x1f596}, {0x1f5a4, 0x1f5a4}, {0x1f5fb, 0x1f64f}, {0x1f680, 0x1f6c5},
+	{0x1f6cc, 0x1f6cc}, {0x1f6d0, 0x1f6d2}, {0x1f6d5, 0x1f6d7}, {0x1f6dc, 0x1f6df}, {0x1f6eb, 0x1f6ec},
+	{0x1f6f4, 0x1f6fc}, {0x1f7e0, 0x1f7eb}, {0x1f7f0, 0x1f7f0}, {0x1f90c, 0x1f93a}, {0x1f93c, 0x1f945},
+	{0x1f947, 0x1f9ff}, {0x1fa70, 0x1faff}, {0x20000, 0x2fffd}, {0x30000, 0x3fffd},
+}
+
+// Function wide reports whether r is displayed in two columns.
+func wide(r rune) bool {
+	_, ok := slices.BinarySearchFunc(wideTable, r, func(e [2]rune, r rune) int {
+		switch {
+		case e[1] < r:
+			return -1
+		case e[0] > r:
+			return 1
+		}
+		return 0
+	})
+	return ok
+}
+
+// Function zero reports whether r takes no column on its own: control characters, combining marks, format characters
+// such as the zero width joiner, and the medial vowels and final consonants of conjoining Hangul.
+func zero(r rune) bool {
+	return r < 0x20 || r >= 0x7f && r < 0xa0 || r >= 0x1160 && r <= 0x11ff ||
+		unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) || r >= 0x1f3fb && r <= 0x1f3ff
+}
+
+// Function width returns the number of terminal columns needed to display s. ANSI escape sequences such as colors are
+// skipped. Characters joined by a zero width joiner, and pairs of regional indicators, are displayed as a single wide
+// glyph. Ambiguous characters are counted as narrow.
+func width(s string) int {
+	n := 0
+	// Width of the previous character, and whether the next one is joined to it.
+	last := 0
+	join := false
+	flag := false
+	for i := 0; i < len(s); {
+		if s[i] == 0x1b && i+1 < len(s) && s[i+1] == '[' {
+			// Control sequence: parameters and intermediate bytes, then a final byte in 0x40-0x7e.
+			i += 2
+			for i < len(s) && (s[i] < 0x40 || s[i] > 0x7e) {
+				i++
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		switch {
+		case r == 0x200d:
+			join = true
+			continue
+		case r == 0xfe0f:
+			// Emoji presentation selector, turns the previous narrow character into a wide emoji.
+			if last == 1 {
+				n++
+				last = 2
+			}
+			continue
+		case zero(r):
+			continue
+		case join:
+			join = false
+			continue
+		case r >= 0x1f1e6 && r <= 0x1f1ff:
+			// Regional indicators are paired into flags.
+			flag = !flag
+			if !flag {
+				continue
+			}
+			last = 2
+		case wide(r):
+			last = 2
+		default:
+			last = 1
+		}
+		n += last
+	}
+	return n
+}